@@ -1,22 +1,41 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ofek/csi-gcs/pkg/driver"
-	"k8s.io/klog"
+	"github.com/ofek/csi-gcs/pkg/flags"
+	"github.com/ofek/csi-gcs/pkg/util"
+	"k8s.io/klog/v2"
 )
 
 var (
 	version            = "development"
 	nodeNameFlag       = flag.String("node-name", "", "Node identifier")
 	driverNameFlag     = flag.String("driver-name", driver.CSIDriverName, "CSI driver name")
+	kubeconfigFlag     = flag.String("kubeconfig", "", "Path to a kubeconfig file to use outside of a cluster, the service account of the pod is used if empty")
 	endpointFlag       = flag.String("csi-endpoint", "unix:///csi/csi.sock", "CSI endpoint")
 	versionFlag        = flag.Bool("version", false, "Print the version and exit")
 	deleteOrphanedPods = flag.Bool("delete-orphaned-pods", false, "Delete Orphaned Pods on StartUp")
+	deleteUnowned      = flag.Bool("delete-unowned-buckets", false, "Allow DeleteVolume to delete buckets that were not provisioned by the driver")
+	clusterIDFlag      = flag.String("cluster-id", "", "Cluster identifier available to bucket name templates")
+	bucketNameTemplate = flag.String("bucket-name-template", "", "Default template for the names of provisioned buckets")
+	capacityBudgets    = flag.String("capacity-budgets", "", "Total capacity of the buckets the driver may provision per project, e.g. project-a=10Ti,project-b=500Gi")
+	allowedAnnotations = flag.String("allowed-annotations", "", "Comma-separated names of the only options that PersistentVolumeClaim annotations may set, each optionally followed by =<pattern>, e.g. dirMode,bucket=team-a-.*")
+	lockedOptions      = flag.String("locked-options", "", "Comma-separated names of options that PersistentVolumeClaim annotations may not set, e.g. bucket,billingProject")
+	enforceAccess      = flag.Bool("enforce-bucket-access-policies", false, "Only allow pods to mount buckets permitted by BucketAccessPolicy resources")
+	forwardLogs        = flag.Bool("forward-gcsfuse-logs", false, "Log the output of gcsfuse for every mount, tagged with the volume and pod")
+	loggingFormat      = flag.String("logging-format", util.LoggingFormatText, "Format of the logs, text or json")
+	otlpEndpoint       = flag.String("otlp-endpoint", "", "Host and port of the OTLP gRPC receiver to export traces to, tracing is disabled if empty")
+	otlpInsecure       = flag.Bool("otlp-insecure", false, "Export traces to the OTLP receiver without TLS")
+	traceSampleRatio   = flag.Float64("trace-sample-ratio", 1, "Fraction of requests that are traced, unless the caller decided already")
+	mountTimeout       = flag.Duration("mount-timeout", 2*time.Minute, "How long mounting a bucket may take before gcsfuse is killed, 0 disables the timeout")
 )
 
 func main() {
@@ -25,6 +44,12 @@ func main() {
 	setEnvVarFlags()
 	flag.Parse()
 
+	verbosity, _ := strconv.Atoi(flag.Lookup("v").Value.String())
+	if err := util.SetLoggingFormat(*loggingFormat, verbosity); err != nil {
+		klog.Error(err.Error())
+		os.Exit(1)
+	}
+
 	if *versionFlag {
 		versionJSON, err := driver.GetVersionJSON()
 		if err != nil {
@@ -35,13 +60,70 @@ func main() {
 		os.Exit(0)
 	}
 
-	d, err := driver.NewGCSDriver(*driverNameFlag, *nodeNameFlag, *endpointFlag, version, *deleteOrphanedPods)
+	budgets, err := util.ParseCapacityBudgets(*capacityBudgets)
+	if err != nil {
+		klog.Error(err.Error())
+		os.Exit(1)
+	}
+
+	locked, err := flags.ParseLockedOptions(*lockedOptions)
 	if err != nil {
 		klog.Error(err.Error())
 		os.Exit(1)
 	}
 
-	if err = d.Run(); err != nil {
+	var policy flags.AnnotationPolicy
+	if *allowedAnnotations != "" {
+		policy, err = flags.ParseAnnotationPolicy(*allowedAnnotations)
+		if err != nil {
+			klog.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	shutdownTracing := func(context.Context) error { return nil }
+	if *otlpEndpoint != "" {
+		shutdownTracing, err = util.SetupTracing(context.Background(), util.TracingOptions{
+			Endpoint:       *otlpEndpoint,
+			Insecure:       *otlpInsecure,
+			SampleRatio:    *traceSampleRatio,
+			ServiceName:    *driverNameFlag,
+			ServiceVersion: version,
+		})
+		if err != nil {
+			klog.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	clientset, gcsClientset, err := util.NewClientsets(*kubeconfigFlag)
+	if err != nil {
+		klog.Error(err.Error())
+		os.Exit(1)
+	}
+
+	d, err := driver.NewGCSDriver(*driverNameFlag, *nodeNameFlag, *endpointFlag, version, clientset, gcsClientset, driver.GCSDriverOptions{
+		DeleteOrphanedPods:   *deleteOrphanedPods,
+		DeleteUnownedBuckets: *deleteUnowned,
+		ClusterID:            *clusterIDFlag,
+		BucketNameTemplate:   *bucketNameTemplate,
+		CapacityBudgets:      budgets,
+		LockedOptions:        locked,
+		AnnotationPolicy:     policy,
+		EnforceBucketAccess:  *enforceAccess,
+		MountTimeout:         *mountTimeout,
+		ForwardGcsfuseLogs:   *forwardLogs,
+	})
+	if err != nil {
+		klog.Error(err.Error())
+		os.Exit(1)
+	}
+
+	err = d.Run()
+	if err := shutdownTracing(context.Background()); err != nil {
+		klog.Error(err.Error())
+	}
+	if err != nil {
 		klog.Error(err.Error())
 		os.Exit(1)
 	}
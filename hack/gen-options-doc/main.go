@@ -0,0 +1,23 @@
+// Command gen-options-doc renders the reference documentation of the driver options.
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/ofek/csi-gcs/pkg/flags"
+)
+
+const output = "docs/options.md"
+
+func main() {
+	path := output
+	if len(os.Args) > 1 {
+		path = os.Args[1]
+	}
+
+	if err := ioutil.WriteFile(path, []byte(flags.MarkdownReference()), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -36,6 +36,8 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 		SchemeGroupVersion,
 		&PublishedVolume{},
 		&PublishedVolumeList{},
+		&BucketAccessPolicy{},
+		&BucketAccessPolicyList{},
 	)
 
 	scheme.AddKnownTypes(
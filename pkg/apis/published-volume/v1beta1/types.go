@@ -16,16 +16,24 @@ type PublishedVolume struct {
 }
 
 type PublishedVolumeSpec struct {
-	Node         string                 `json:"node"`
-	TargetPath   string                 `json:"targetPath"`
-	VolumeHandle string                 `json:"volumeHandle"`
-	Options      map[string]string      `json:"options"`
-	Pod          PublishedVolumeSpecPod `json:"pod"`
+	Node         string            `json:"node"`
+	TargetPath   string            `json:"targetPath"`
+	VolumeHandle string            `json:"volumeHandle"`
+	Options      map[string]string `json:"options"`
+	// Sources maps every option to the source of its value
+	// +optional
+	Sources map[string]string `json:"sources,omitempty"`
+	// ReadOnly is set for mounts that cannot write to the volume
+	// +optional
+	ReadOnly bool                   `json:"readOnly,omitempty"`
+	Pod      PublishedVolumeSpecPod `json:"pod"`
 }
 
 type PublishedVolumeSpecPod struct {
 	Name      string `json:"name"`
 	Namespace string `json:"namespace"`
+	// +optional
+	UID string `json:"uid,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -37,3 +45,46 @@ type PublishedVolumeList struct {
 
 	Items []PublishedVolume `json:"items"`
 }
+
+type BucketAccessMode string
+
+const (
+	BucketAccessReadOnly  BucketAccessMode = "ReadOnly"
+	BucketAccessReadWrite BucketAccessMode = "ReadWrite"
+)
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// BucketAccessPolicy allows pods of the selected namespaces to mount the matching buckets
+type BucketAccessPolicy struct {
+	metav1.TypeMeta `json:",inline"`
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BucketAccessPolicySpec `json:"spec"`
+}
+
+type BucketAccessPolicySpec struct {
+	// Namespaces selects namespaces by name
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector selects namespaces by label
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Buckets are shell patterns of the bucket names that may be mounted, e.g. team-a-*
+	Buckets []string `json:"buckets"`
+	// Mode is either ReadOnly or ReadWrite
+	Mode BucketAccessMode `json:"mode"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+type BucketAccessPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	// +optional
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []BucketAccessPolicy `json:"items"`
+}
@@ -28,6 +28,10 @@ type FakeGcsV1beta1 struct {
 	*testing.Fake
 }
 
+func (c *FakeGcsV1beta1) BucketAccessPolicies() v1beta1.BucketAccessPolicyInterface {
+	return &FakeBucketAccessPolicies{c}
+}
+
 func (c *FakeGcsV1beta1) PublishedVolumes() v1beta1.PublishedVolumeInterface {
 	return &FakePublishedVolumes{c}
 }
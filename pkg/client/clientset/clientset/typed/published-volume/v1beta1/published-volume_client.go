@@ -28,6 +28,7 @@ import (
 
 type GcsV1beta1Interface interface {
 	RESTClient() rest.Interface
+	BucketAccessPoliciesGetter
 	PublishedVolumesGetter
 }
 
@@ -36,6 +37,10 @@ type GcsV1beta1Client struct {
 	restClient rest.Interface
 }
 
+func (c *GcsV1beta1Client) BucketAccessPolicies() BucketAccessPolicyInterface {
+	return newBucketAccessPolicies(c)
+}
+
 func (c *GcsV1beta1Client) PublishedVolumes() PublishedVolumeInterface {
 	return newPublishedVolumes(c)
 }
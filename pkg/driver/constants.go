@@ -4,7 +4,24 @@ const (
 	CSIDriverName   = "gcs.csi.ofek.dev"
 	BucketMountPath = "/var/lib/kubelet/pods"
 	KeyStoragePath  = "/tmp/keys"
-	DefaultGid      = 63147
-	DefaultDirMode  = 0775
-	DefaultFileMode = 0664
+	// ConfigStoragePath holds the gcsfuse config file of every mount
+	ConfigStoragePath = "/tmp/configs"
+	// LogStoragePath holds the gcsfuse log file of every mount
+	LogStoragePath = "/tmp/logs"
+	// GcsfuseLogMaxSize is the size at which gcsfuse log files are rotated
+	GcsfuseLogMaxSize = 10 * 1024 * 1024
+	// GcsfuseLogTailLines is the number of gcsfuse log lines included in mount errors
+	GcsfuseLogTailLines = 20
+	// GcsfuseConfigFileMajorVersion is the first major version of gcsfuse that is configured by a config file
+	GcsfuseConfigFileMajorVersion = 2
+	DefaultLocation               = "US"
+
+	// Keys of the volume context set by the kubelet, see podInfoOnMount
+	VolumeContextPodName      = "csi.storage.k8s.io/pod.name"
+	VolumeContextPodNamespace = "csi.storage.k8s.io/pod.namespace"
+	VolumeContextPodUID       = "csi.storage.k8s.io/pod.uid"
+	VolumeContextEphemeral    = "csi.storage.k8s.io/ephemeral"
+
+	TopologyKeyRegion = "topology.gcs.csi.ofek.dev/region"
+	TopologyKeyZone   = "topology.gcs.csi.ofek.dev/zone"
 )
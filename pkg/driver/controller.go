@@ -2,78 +2,119 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"net/http"
+	"strconv"
 
 	"cloud.google.com/go/storage"
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"github.com/ofek/csi-gcs/pkg/flags"
 	"github.com/ofek/csi-gcs/pkg/util"
-	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"k8s.io/klog"
+	"k8s.io/klog/v2"
 )
 
-func (d *GCSDriver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
-	klog.V(4).Infof("Method CreateVolume called with: %s", protosanitizer.StripSecrets(req))
+const bucketNameAttempts = 5
+
+var errBucketNameTaken = errors.New("bucket name is taken")
 
+func (d *GCSDriver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 	if req.Name == "" {
 		return nil, status.Error(codes.InvalidArgument, "missing name")
 	}
+
+	if !d.volumeLocks.TryAcquire(req.Name) {
+		return nil, status.Errorf(codes.Aborted, "An operation on volume %s is already in progress", req.Name)
+	}
+	defer d.volumeLocks.Release(req.Name)
+
 	if len(req.VolumeCapabilities) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "missing volume capabilities")
 	}
 
+	if err := util.ValidateVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Merge options by precedence, see flags.Precedence
+	merger := flags.NewMerger(ctx, flags.Defaults(flags.SCOPE_CONTROLLER))
+
+	if err := merger.Merge(flags.SOURCE_SECRET, req.Secrets); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	for _, capability := range req.GetVolumeCapabilities() {
-		if capability.GetMount() != nil && capability.GetBlock() == nil {
-			continue
+		if err := merger.MergeMountOptions(capability.GetMount().GetMountFlags()); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
 		}
-		return nil, status.Error(codes.InvalidArgument, "Only volumeMode Filesystem is supported")
 	}
 
-	// Default Options
-	var options = map[string]string{
-		"bucket":   util.BucketName(req.Name),
-		"location": "US",
-		"kmsKeyId": "",
+	if err := merger.MergeAnnotations(flags.SOURCE_PARAMETER, req.Parameters); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	// Merge Secret Options
-	options = flags.MergeSecret(options, req.Secrets)
-
-	// Merge MountFlag Options
-	for _, capability := range req.GetVolumeCapabilities() {
-		options = flags.MergeMountOptions(options, capability.GetMount().GetMountFlags())
+	// Lock and restrict options before merging PVC annotations
+	lockedOptions, err := flags.ParseLockedOptions(merger.Options()[flags.FLAG_LOCKED_OPTIONS])
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := merger.Lock(append(lockedOptions, d.lockedOptions...)...); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if value := merger.Options()[flags.FLAG_ALLOWED_ANNOTATIONS]; value != "" {
+		policy, err := flags.ParseAnnotationPolicy(value)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		merger.Restrict(policy)
+	} else {
+		merger.Restrict(d.annotationPolicy)
 	}
 
-	// Merge PVC Annotation Options
 	pvcName, pvcNameSelected := req.Parameters["csi.storage.k8s.io/pvc/name"]
 	pvcNamespace, pvcNamespaceSelected := req.Parameters["csi.storage.k8s.io/pvc/namespace"]
 
-	var pvcAnnotations = map[string]string{}
-
 	if pvcNameSelected && pvcNamespaceSelected {
-		loadedPvcAnnotations, err := util.GetPvcAnnotations(ctx, pvcName, pvcNamespace)
+		pvcAnnotations, err := util.GetPvcAnnotations(ctx, d.clientset, pvcName, pvcNamespace)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "Failed to load PersistentVolumeClaim: %v", err)
 		}
 
-		pvcAnnotations = loadedPvcAnnotations
+		if err := merger.MergeAnnotations(flags.SOURCE_ANNOTATION, pvcAnnotations); err != nil {
+			if flags.IsDenied(err) {
+				err = status.Error(codes.PermissionDenied, err.Error())
+				d.recordPvcEvent(ctx, req, EventReasonAnnotationDenied, err)
+				return nil, err
+			}
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	options := merger.Options()
+
+	// Place the bucket near the nodes unless a location is explicitly set
+	if options[flags.FLAG_LOCATION] == "" {
+		options[flags.FLAG_LOCATION] = util.LocationFromTopology(req.GetAccessibilityRequirements(), TopologyKeyRegion)
+	}
+	if options[flags.FLAG_LOCATION] == "" {
+		options[flags.FLAG_LOCATION] = DefaultLocation
 	}
-	options = flags.MergeAnnotations(options, pvcAnnotations)
 
-	// Merge Context
-	if req.Parameters != nil {
-		options = flags.MergeAnnotations(options, req.Parameters)
+	if _, err := flags.ParseOptions(options); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
 	var clientOpt option.ClientOption
 	if len(req.Secrets) == 0 {
 		// Find default credentials
-		creds, err := google.FindDefaultCredentials(ctx, storage.ScopeReadOnly)
+		creds, err := util.FindDefaultCredentials(ctx, storage.ScopeReadOnly)
 		if err != nil {
+			d.recordPvcEvent(ctx, req, EventReasonCredentialsInvalid, err)
 			return nil, err
 		}
 		clientOpt = option.WithCredentials(creds)
@@ -81,6 +122,7 @@ func (d *GCSDriver) CreateVolume(ctx context.Context, req *csi.CreateVolumeReque
 		// Retrieve Secret Key
 		keyFile, err := util.GetKey(req.Secrets, KeyStoragePath)
 		if err != nil {
+			d.recordPvcEvent(ctx, req, EventReasonCredentialsInvalid, err)
 			return nil, err
 		}
 		clientOpt = option.WithCredentialsFile(keyFile)
@@ -90,33 +132,46 @@ func (d *GCSDriver) CreateVolume(ctx context.Context, req *csi.CreateVolumeReque
 	// Creates a client.
 	client, err := storage.NewClient(ctx, clientOpt)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to create client: %v", err)
+		err = status.Errorf(codes.Internal, "Failed to create client: %v", err)
+		d.recordPvcEvent(ctx, req, EventReasonCredentialsInvalid, err)
+		return nil, err
 	}
 
-	// Creates a Bucket instance.
-	bucket := client.Bucket(options[flags.FLAG_BUCKET])
+	// Provision a prefix of the parent bucket instead of a new bucket
+	if parentBucket := options[flags.FLAG_PARENT_BUCKET]; parentBucket != "" {
+		resp, err := d.createPrefixVolume(ctx, client.Bucket(parentBucket), req, merger, options)
+		if err != nil {
+			d.recordPvcEvent(ctx, req, EventReasonPrefixCreateFailed, err)
+		}
+		return resp, err
+	}
 
-	// Check if Bucket Exists
-	_, err = bucket.Attrs(ctx)
-	if err == nil {
-		klog.V(2).Infof("Bucket '%s' exists", options[flags.FLAG_BUCKET])
-	} else {
-		klog.V(2).Infof("Bucket '%s' does not exist, creating", options[flags.FLAG_BUCKET])
+	// Throttle provisioning once the capacity budget of the project is used up
+	newCapacity := int64(req.GetCapacityRange().GetRequiredBytes())
+	if err := d.checkCapacityBudget(ctx, client, req.Name, options, newCapacity); err != nil {
+		return nil, err
+	}
 
-		projectId, projectIdExists := options[flags.FLAG_PROJECT_ID]
-		if !projectIdExists {
-			return nil, status.Errorf(codes.InvalidArgument, "Project Id not provided, bucket can't be created: %s", options[flags.FLAG_BUCKET])
+	// Creates a Bucket instance.
+	var bucket *storage.BucketHandle
+	if options[flags.FLAG_BUCKET] != "" {
+		bucket = client.Bucket(options[flags.FLAG_BUCKET])
+		if err := d.provisionBucket(ctx, bucket, req, options, false); err != nil {
+			d.recordPvcEvent(ctx, req, EventReasonBucketCreateFailed, err)
+			return nil, err
 		}
-		if err := bucket.Create(ctx, projectId, &storage.BucketAttrs{Location: options[flags.FLAG_LOCATION],
-			Encryption: &storage.BucketEncryption{DefaultKMSKeyName: options[flags.FLAG_KMS_KEY_ID]}}); err != nil {
-			return nil, status.Errorf(codes.Internal, "Failed to create bucket: %v", err)
+	} else {
+		bucket, err = d.provisionGeneratedBucket(ctx, client, req, options)
+		if err != nil {
+			d.recordPvcEvent(ctx, req, EventReasonBucketCreateFailed, err)
+			return nil, err
 		}
 	}
 
 	// Get Capacity
-	bucketAttrs, err := bucket.Attrs(ctx)
+	bucketAttrs, err := util.GetBucketAttrs(ctx, bucket)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to get bucket attrs: %v", err)
+		return nil, util.GCSErrorf(err, "Failed to get bucket attrs: %v", err)
 	}
 
 	existingCapacity, err := util.BucketCapacity(bucketAttrs)
@@ -125,11 +180,10 @@ func (d *GCSDriver) CreateVolume(ctx context.Context, req *csi.CreateVolumeReque
 	}
 
 	// Check / Set Capacity
-	newCapacity := int64(req.GetCapacityRange().GetRequiredBytes())
 	if existingCapacity == 0 {
 		_, err = util.SetBucketCapacity(ctx, bucket, newCapacity)
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "Failed to set bucket capacity: %v", err)
+			return nil, util.GCSErrorf(err, "Failed to set bucket capacity: %v", err)
 		}
 	} else if existingCapacity < newCapacity {
 		return nil, status.Error(codes.AlreadyExists, fmt.Sprintf("Volume with the same name: %s but with smaller size already exist", options[flags.FLAG_BUCKET]))
@@ -137,24 +191,222 @@ func (d *GCSDriver) CreateVolume(ctx context.Context, req *csi.CreateVolumeReque
 
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			VolumeId:      options[flags.FLAG_BUCKET],
-			VolumeContext: options,
+			VolumeId:           util.NewVolumeID(options[flags.FLAG_PROJECT_ID], options[flags.FLAG_BUCKET], "").String(),
+			VolumeContext:      volumeContext(merger, options),
+			CapacityBytes:      newCapacity,
+			AccessibleTopology: util.AccessibleTopology(req.GetAccessibilityRequirements(), TopologyKeyRegion, bucketAttrs.Location),
+		},
+	}, nil
+}
+
+// capacityBudget returns the capacity budget of the project, preferring the StorageClass parameter.
+func (d *GCSDriver) capacityBudget(options map[string]string) (budget int64, limited bool, err error) {
+	if value := options[flags.FLAG_CAPACITY_BUDGET]; value != "" {
+		budget, err = util.ParseCapacity(value)
+		if err != nil {
+			return 0, false, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return budget, true, nil
+	}
+
+	budget, limited = d.capacityBudgets[options[flags.FLAG_PROJECT_ID]]
+	return budget, limited, nil
+}
+
+func (d *GCSDriver) checkCapacityBudget(ctx context.Context, client *storage.Client, volumeName string, options map[string]string, capacity int64) error {
+	budget, limited, err := d.capacityBudget(options)
+	if err != nil || !limited {
+		return err
+	}
+	if options[flags.FLAG_PROJECT_ID] == "" {
+		return status.Error(codes.InvalidArgument, "Project Id not provided, capacity budget can't be enforced")
+	}
+
+	provisioned, err := util.ProvisionedCapacity(ctx, client, options[flags.FLAG_PROJECT_ID], d.name, volumeName)
+	if err != nil {
+		return util.GCSErrorf(err, "Failed to get provisioned capacity: %v", err)
+	}
+
+	if provisioned+capacity > budget {
+		return status.Errorf(codes.ResourceExhausted, "Capacity budget of project %s exceeded: %d of %d bytes provisioned, %d requested", options[flags.FLAG_PROJECT_ID], provisioned, budget, capacity)
+	}
+
+	return nil
+}
+
+// provisionGeneratedBucket renders the bucket name template and provisions the bucket, trying other names if the
+// rendered name is taken by a bucket the volume can't claim, e.g. one owned by another project.
+func (d *GCSDriver) provisionGeneratedBucket(ctx context.Context, client *storage.Client, req *csi.CreateVolumeRequest, options map[string]string) (*storage.BucketHandle, error) {
+	nameTemplate := options[flags.FLAG_BUCKET_NAME_TEMPLATE]
+	if nameTemplate == "" {
+		nameTemplate = d.bucketNameTemplate
+	}
+	if nameTemplate == "" {
+		nameTemplate = util.DefaultBucketNameTemplate
+	}
+
+	params := util.BucketNameParams{
+		ClusterID:  d.clusterID,
+		Namespace:  req.Parameters["csi.storage.k8s.io/pvc/namespace"],
+		PVCName:    req.Parameters["csi.storage.k8s.io/pvc/name"],
+		VolumeName: req.Name,
+	}
+
+	for attempt := 0; attempt < bucketNameAttempts; attempt++ {
+		bucketName, err := util.RenderBucketName(nameTemplate, params, attempt)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		options[flags.FLAG_BUCKET] = bucketName
+		bucket := client.Bucket(bucketName)
+
+		err = d.provisionBucket(ctx, bucket, req, options, true)
+		if err == errBucketNameTaken {
+			klog.FromContext(ctx).V(2).Info("Bucket name is taken, trying another one", "bucket", bucketName)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		return bucket, nil
+	}
+
+	return nil, status.Errorf(codes.AlreadyExists, "No available bucket name for volume %s after %d attempts", req.Name, bucketNameAttempts)
+}
+
+// provisionBucket makes sure the bucket exists, creating it if needed. Existing buckets that were provisioned for
+// another volume are rejected, and so are any existing buckets the volume doesn't own if the name was generated.
+func (d *GCSDriver) provisionBucket(ctx context.Context, bucket *storage.BucketHandle, req *csi.CreateVolumeRequest, options map[string]string, generated bool) error {
+	bucketName := options[flags.FLAG_BUCKET]
+
+	// Check if Bucket Exists
+	existingAttrs, err := util.GetBucketAttrs(ctx, bucket)
+	if err == nil {
+		klog.FromContext(ctx).V(2).Info("Bucket exists", "bucket", bucketName)
+
+		if util.IsBucketOwnedByVolume(existingAttrs, d.name, req.Name) {
+			return nil
+		}
+		if generated {
+			return errBucketNameTaken
+		}
+		if util.IsBucketOwnedByDriver(existingAttrs, d.name) {
+			return status.Errorf(codes.AlreadyExists, "Bucket '%s' was provisioned for another volume", bucketName)
+		}
+		return nil
+	}
+
+	if generated && util.GCSErrorCode(err) == codes.PermissionDenied {
+		// The name is globally unique, a bucket we can't access means it's used by another project
+		return errBucketNameTaken
+	} else if util.GCSErrorCode(err) != codes.NotFound {
+		return util.GCSErrorf(err, "Failed to get bucket attrs: %v", err)
+	}
+
+	klog.FromContext(ctx).V(2).Info("Bucket does not exist, creating", "bucket", bucketName)
+
+	projectId, projectIdExists := options[flags.FLAG_PROJECT_ID]
+	if !projectIdExists {
+		return status.Errorf(codes.InvalidArgument, "Project Id not provided, bucket can't be created: %s", bucketName)
+	}
+	if err := util.CreateBucket(ctx, bucket, projectId, &storage.BucketAttrs{Location: options[flags.FLAG_LOCATION],
+		Encryption: &storage.BucketEncryption{DefaultKMSKeyName: options[flags.FLAG_KMS_KEY_ID]},
+		Labels:     util.BucketOwnerLabels(d.name, req.Name)}); err != nil {
+		// The name is globally unique, a conflict means it's used by a bucket we can't see
+		var apiErr *googleapi.Error
+		if generated && errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict {
+			return errBucketNameTaken
+		}
+		return util.GCSErrorf(err, "Failed to create bucket: %v", err)
+	}
+
+	return nil
+}
+
+// volumeContext returns the options forwarded to the node along with the source of every value.
+func volumeContext(merger *flags.Merger, options map[string]string) map[string]string {
+	result := flags.VolumeContext(options)
+	result[flags.VOLUME_CONTEXT_OPTION_SOURCES] = flags.FormatSources(merger.Sources(result))
+
+	return result
+}
+
+func (d *GCSDriver) createPrefixVolume(ctx context.Context, bucket *storage.BucketHandle, req *csi.CreateVolumeRequest, merger *flags.Merger, options map[string]string) (*csi.CreateVolumeResponse, error) {
+	parentBucket := options[flags.FLAG_PARENT_BUCKET]
+	prefix := req.Name
+	volumeID := util.NewVolumeID(options[flags.FLAG_PROJECT_ID], parentBucket, prefix)
+
+	// The parent bucket is never created by the driver
+	bucketExists, err := util.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, util.GCSErrorf(err, "Failed to check if bucket exists: %v", err)
+	}
+	if !bucketExists {
+		return nil, status.Errorf(codes.FailedPrecondition, "Parent bucket '%s' does not exist", parentBucket)
+	}
+
+	newCapacity := int64(req.GetCapacityRange().GetRequiredBytes())
+
+	// Check if Prefix Exists
+	attrs, err := util.GetPrefixAttrs(ctx, bucket, prefix)
+	if err == nil {
+		klog.FromContext(ctx).V(2).Info("Prefix exists", "bucket", parentBucket, "prefix", prefix)
+
+		if !util.IsPrefixOwnedByVolume(attrs, d.name, req.Name) {
+			return nil, status.Errorf(codes.AlreadyExists, "Prefix '%s' of bucket '%s' was not provisioned for this volume", prefix, parentBucket)
+		}
+
+		existingCapacity, err := util.PrefixCapacity(attrs)
+		if err != nil {
+			return nil, err
+		}
+		if existingCapacity < newCapacity {
+			return nil, status.Error(codes.AlreadyExists, fmt.Sprintf("Volume with the same name: %s but with smaller size already exist", volumeID))
+		}
+	} else if err == storage.ErrObjectNotExist {
+		klog.FromContext(ctx).V(2).Info("Prefix does not exist, creating", "bucket", parentBucket, "prefix", prefix)
+
+		metadata := util.BucketOwnerLabels(d.name, req.Name)
+		metadata["capacity"] = strconv.FormatInt(newCapacity, 10)
+		if _, err := util.CreatePrefix(ctx, bucket, prefix, metadata); err != nil {
+			return nil, util.GCSErrorf(err, "Failed to create prefix: %v", err)
+		}
+	} else {
+		return nil, util.GCSErrorf(err, "Failed to get prefix attrs: %v", err)
+	}
+
+	options[flags.FLAG_BUCKET] = parentBucket
+	options[flags.FLAG_ONLY_DIR] = prefix
+
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      volumeID.String(),
+			VolumeContext: volumeContext(merger, options),
 			CapacityBytes: newCapacity,
 		},
 	}, nil
 }
 
 func (d *GCSDriver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
-	klog.V(4).Infof("Method DeleteVolume called with: %s", protosanitizer.StripSecrets(req))
-
 	if req.VolumeId == "" {
 		return nil, status.Error(codes.InvalidArgument, "missing volume id")
 	}
 
+	if !d.volumeLocks.TryAcquire(req.VolumeId) {
+		return nil, status.Errorf(codes.Aborted, "An operation on volume %s is already in progress", req.VolumeId)
+	}
+	defer d.volumeLocks.Release(req.VolumeId)
+
+	volumeID, err := util.ParseVolumeID(req.VolumeId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	var clientOpt option.ClientOption
 	if len(req.Secrets) == 0 {
 		// Find default credentials
-		creds, err := google.FindDefaultCredentials(ctx, storage.ScopeReadOnly)
+		creds, err := util.FindDefaultCredentials(ctx, storage.ScopeReadOnly)
 		if err != nil {
 			return nil, err
 		}
@@ -175,24 +427,67 @@ func (d *GCSDriver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeReque
 		return nil, status.Errorf(codes.Internal, "Failed to create client: %v", err)
 	}
 
+	bucketName, prefix := volumeID.Bucket, volumeID.Prefix
+
 	// Creates a Bucket instance.
-	bucket := client.Bucket(req.VolumeId)
+	bucket := client.Bucket(bucketName)
 
-	_, err = bucket.Attrs(ctx)
+	if prefix != "" {
+		return d.deletePrefixVolume(ctx, bucket, bucketName, prefix)
+	}
+
+	attrs, err := util.GetBucketAttrs(ctx, bucket)
 	if err == nil {
-		if err := bucket.Delete(ctx); err != nil {
-			return nil, status.Errorf(codes.Internal, "Error deleting bucket %s, %v", req.VolumeId, err)
+		if !util.IsBucketOwnedByDriver(attrs, d.name) {
+			if !d.deleteUnownedBuckets {
+				return nil, status.Errorf(codes.FailedPrecondition, "Bucket %s was not provisioned by %s, refusing to delete it", bucketName, d.name)
+			}
+			klog.FromContext(ctx).Info("Bucket was not provisioned by the driver, deleting anyway", "bucket", bucketName, "driver", d.name)
+		}
+
+		if err := util.DeleteBucket(ctx, bucket); err != nil {
+			return nil, util.GCSErrorf(err, "Error deleting bucket %s, %v", bucketName, err)
 		}
+	} else if util.GCSErrorCode(err) == codes.NotFound {
+		klog.FromContext(ctx).V(2).Info("Bucket does not exist, not deleting", "bucket", bucketName)
 	} else {
-		klog.V(2).Infof("Bucket '%s' does not exist, not deleting", req.VolumeId)
+		return nil, util.GCSErrorf(err, "Failed to get bucket attrs: %v", err)
 	}
 
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
-func (d *GCSDriver) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
-	klog.V(4).Infof("Method ControllerGetCapabilities called with: %s", protosanitizer.StripSecrets(req))
+func (d *GCSDriver) deletePrefixVolume(ctx context.Context, bucket *storage.BucketHandle, bucketName string, prefix string) (*csi.DeleteVolumeResponse, error) {
+	// Object lookups report a missing bucket like a missing object, so check the bucket first
+	exists, err := util.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, util.GCSErrorf(err, "Failed to check if bucket %s exists: %v", bucketName, err)
+	}
+	if !exists {
+		klog.FromContext(ctx).V(2).Info("Bucket does not exist, not deleting prefix", "bucket", bucketName, "prefix", prefix)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
 
+	attrs, err := util.GetPrefixAttrs(ctx, bucket, prefix)
+	if err == nil {
+		if !util.IsPrefixOwnedByDriver(attrs, d.name) && !d.deleteUnownedBuckets {
+			return nil, status.Errorf(codes.FailedPrecondition, "Prefix %s of bucket %s was not provisioned by %s, refusing to delete it", prefix, bucketName, d.name)
+		}
+	} else if util.GCSErrorCode(err) != codes.NotFound {
+		return nil, util.GCSErrorf(err, "Failed to get prefix attrs: %v", err)
+	} else if !d.deleteUnownedBuckets {
+		klog.FromContext(ctx).V(2).Info("Prefix does not exist, not deleting", "bucket", bucketName, "prefix", prefix)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	if err := util.DeletePrefix(ctx, bucket, prefix); err != nil {
+		return nil, util.GCSErrorf(err, "Error deleting prefix %s of bucket %s, %v", prefix, bucketName, err)
+	}
+
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+func (d *GCSDriver) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
 	return &csi.ControllerGetCapabilitiesResponse{
 		Capabilities: []*csi.ControllerServiceCapability{
 			{
@@ -209,26 +504,43 @@ func (d *GCSDriver) ControllerGetCapabilities(ctx context.Context, req *csi.Cont
 					},
 				},
 			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+					},
+				},
+			},
 		},
 	}, nil
 }
 
 func (d *GCSDriver) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
-	klog.V(4).Infof("Method ValidateVolumeCapabilities called with: %s", protosanitizer.StripSecrets(req))
-
 	if req.VolumeId == "" {
 		return nil, status.Error(codes.InvalidArgument, "missing volume id")
 	}
+
+	volumeID, err := util.ParseVolumeID(req.VolumeId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	if len(req.VolumeCapabilities) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "missing volume capabilities")
 	}
 
-	bucketName := req.VolumeId
+	bucketName := volumeID.Bucket
 
 	var clientOpt option.ClientOption
 	if len(req.Secrets) == 0 {
 		// Find default credentials
-		creds, err := google.FindDefaultCredentials(ctx, storage.ScopeReadOnly)
+		creds, err := util.FindDefaultCredentials(ctx, storage.ScopeReadOnly)
 		if err != nil {
 			return nil, err
 		}
@@ -252,17 +564,16 @@ func (d *GCSDriver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Val
 	// Creates a Bucket instance.
 	bucket := client.Bucket(bucketName)
 
-	_, err = bucket.Attrs(ctx)
-
+	_, err = util.GetBucketAttrs(ctx, bucket)
 	if err != nil {
-		return nil, status.Error(codes.NotFound, "volume does not exist")
+		if util.GCSErrorCode(err) == codes.NotFound {
+			return nil, status.Error(codes.NotFound, "volume does not exist")
+		}
+		return nil, util.GCSErrorf(err, "Failed to get bucket attrs: %v", err)
 	}
 
-	for _, capability := range req.GetVolumeCapabilities() {
-		if capability.GetMount() != nil && capability.GetBlock() == nil {
-			continue
-		}
-		return &csi.ValidateVolumeCapabilitiesResponse{Message: "Only volumeMode Filesystem is supported"}, nil
+	if err := util.ValidateVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
 	}
 
 	return &csi.ValidateVolumeCapabilitiesResponse{
@@ -276,64 +587,96 @@ func (d *GCSDriver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Val
 }
 
 func (d *GCSDriver) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
-	klog.V(4).Infof("Method ControllerPublishVolume called with: %s", protosanitizer.StripSecrets(req))
-
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
 func (d *GCSDriver) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
-	klog.V(4).Infof("Method ControllerUnpublishVolume called with: %s", protosanitizer.StripSecrets(req))
-
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
 func (d *GCSDriver) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
-	klog.V(4).Infof("Method GetCapacity called with: %s", protosanitizer.StripSecrets(req))
+	if err := flags.ValidateAnnotations(flags.SOURCE_PARAMETER, req.Parameters); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	options := flags.MergeAnnotations(map[string]string{}, req.Parameters)
 
-	return nil, status.Error(codes.Unimplemented, "")
+	budget, limited, err := d.capacityBudget(options)
+	if err != nil {
+		return nil, err
+	}
+	if !limited {
+		return &csi.GetCapacityResponse{AvailableCapacity: math.MaxInt64}, nil
+	}
+
+	projectId := options[flags.FLAG_PROJECT_ID]
+	if projectId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Project Id not provided, capacity can't be computed")
+	}
+
+	// Find default credentials, there are no secrets for this call
+	creds, err := util.FindDefaultCredentials(ctx, storage.ScopeReadOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	// Creates a client.
+	client, err := storage.NewClient(ctx, option.WithCredentials(creds))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to create client: %v", err)
+	}
+
+	provisioned, err := util.ProvisionedCapacity(ctx, client, projectId, d.name, "")
+	if err != nil {
+		return nil, util.GCSErrorf(err, "Failed to get provisioned capacity: %v", err)
+	}
+
+	available := budget - provisioned
+	if available < 0 {
+		available = 0
+	}
+
+	return &csi.GetCapacityResponse{AvailableCapacity: available}, nil
 }
 
 func (d *GCSDriver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
-	klog.V(4).Infof("Method ListVolumes called with: %s", protosanitizer.StripSecrets(req))
-
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
 func (d *GCSDriver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
-	klog.V(4).Infof("Method CreateSnapshot called with: %s", protosanitizer.StripSecrets(req))
-
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
 func (d *GCSDriver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
-	klog.V(4).Infof("Method DeleteSnapshot called with: %s", protosanitizer.StripSecrets(req))
-
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
 func (d *GCSDriver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
-	klog.V(4).Infof("Method ListSnapshots called with: %s", protosanitizer.StripSecrets(req))
-
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
 func (d *GCSDriver) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
-	klog.V(4).Infof("Method ControllerGetVolume called with: %s", protosanitizer.StripSecrets(req))
-
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
 func (d *GCSDriver) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
-	klog.V(4).Infof("Method ControllerExpandVolume called with: %s", protosanitizer.StripSecrets(req))
-
 	if req.VolumeId == "" {
 		return nil, status.Error(codes.InvalidArgument, "missing volume id")
 	}
 
+	if !d.volumeLocks.TryAcquire(req.VolumeId) {
+		return nil, status.Errorf(codes.Aborted, "An operation on volume %s is already in progress", req.VolumeId)
+	}
+	defer d.volumeLocks.Release(req.VolumeId)
+
+	volumeID, err := util.ParseVolumeID(req.VolumeId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	var clientOpt option.ClientOption
 	if len(req.Secrets) == 0 {
 		// Find default credentials
-		creds, err := google.FindDefaultCredentials(ctx, storage.ScopeReadOnly)
+		creds, err := util.FindDefaultCredentials(ctx, storage.ScopeReadOnly)
 		if err != nil {
 			return nil, err
 		}
@@ -354,21 +697,29 @@ func (d *GCSDriver) ControllerExpandVolume(ctx context.Context, req *csi.Control
 		return nil, status.Errorf(codes.Internal, "Failed to create client: %v", err)
 	}
 
+	bucketName, prefix := volumeID.Bucket, volumeID.Prefix
+
 	// Creates a Bucket instance.
-	bucket := client.Bucket(req.VolumeId)
+	bucket := client.Bucket(bucketName)
+
+	if prefix != "" {
+		return expandPrefixVolume(ctx, bucket, bucketName, prefix, req)
+	}
 
 	// Check if Bucket Exists
-	_, err = bucket.Attrs(ctx)
+	_, err = util.GetBucketAttrs(ctx, bucket)
 	if err == nil {
-		klog.V(2).Infof("Bucket '%s' exists", req.VolumeId)
+		klog.FromContext(ctx).V(2).Info("Bucket exists", "bucket", bucketName)
+	} else if util.GCSErrorCode(err) == codes.NotFound {
+		return nil, status.Errorf(codes.NotFound, "Bucket '%s' does not exist", bucketName)
 	} else {
-		return nil, status.Errorf(codes.NotFound, "Bucket '%s' does not exist", req.VolumeId)
+		return nil, util.GCSErrorf(err, "Failed to get bucket attrs: %v", err)
 	}
 
 	// Get Capacity
-	bucketAttrs, err := bucket.Attrs(ctx)
+	bucketAttrs, err := util.GetBucketAttrs(ctx, bucket)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to get bucket attrs: %v", err)
+		return nil, util.GCSErrorf(err, "Failed to get bucket attrs: %v", err)
 	}
 
 	existingCapacity, err := util.BucketCapacity(bucketAttrs)
@@ -381,7 +732,35 @@ func (d *GCSDriver) ControllerExpandVolume(ctx context.Context, req *csi.Control
 	if newCapacity > existingCapacity {
 		_, err = util.SetBucketCapacity(ctx, bucket, newCapacity)
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "Failed to set bucket capacity: %v", err)
+			return nil, util.GCSErrorf(err, "Failed to set bucket capacity: %v", err)
+		}
+	}
+
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         newCapacity,
+		NodeExpansionRequired: false,
+	}, nil
+}
+
+func expandPrefixVolume(ctx context.Context, bucket *storage.BucketHandle, bucketName string, prefix string, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	attrs, err := util.GetPrefixAttrs(ctx, bucket, prefix)
+	if util.GCSErrorCode(err) == codes.NotFound {
+		return nil, status.Errorf(codes.NotFound, "Prefix '%s' of bucket '%s' does not exist", prefix, bucketName)
+	} else if err != nil {
+		return nil, util.GCSErrorf(err, "Failed to get prefix attrs: %v", err)
+	}
+
+	existingCapacity, err := util.PrefixCapacity(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check / Set Capacity
+	newCapacity := int64(req.GetCapacityRange().GetRequiredBytes())
+	if newCapacity > existingCapacity {
+		_, err = util.SetPrefixCapacity(ctx, bucket, attrs, newCapacity)
+		if err != nil {
+			return nil, util.GCSErrorf(err, "Failed to set prefix capacity: %v", err)
 		}
 	}
 
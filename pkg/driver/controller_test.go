@@ -0,0 +1,468 @@
+package driver_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	gcsfake "github.com/ofek/csi-gcs/pkg/client/clientset/clientset/fake"
+	. "github.com/ofek/csi-gcs/pkg/driver"
+	"github.com/ofek/csi-gcs/pkg/flags"
+	"github.com/ofek/csi-gcs/pkg/util"
+)
+
+var _ = Describe("Controller", func() {
+	var (
+		driver    *GCSDriver
+		clientset *k8sfake.Clientset
+		gcs       *fakeStorage
+		options   GCSDriverOptions
+	)
+
+	BeforeEach(func() {
+		gcs = startFakeStorage()
+		clientset = k8sfake.NewSimpleClientset(
+			&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default"}},
+		)
+		options = GCSDriverOptions{}
+	})
+
+	JustBeforeEach(func() {
+		driver = newDriver(clientset, gcsfake.NewSimpleClientset(), options)
+	})
+
+	AfterEach(func() {
+		gcs.Close()
+	})
+
+	secrets := map[string]string{"key": "{}"}
+	capabilities := []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)}
+
+	createVolumeRequest := func(parameters map[string]string) *csi.CreateVolumeRequest {
+		parameters["gcs.csi.ofek.dev/project-id"] = "project"
+		parameters["csi.storage.k8s.io/pvc/name"] = "data"
+		parameters["csi.storage.k8s.io/pvc/namespace"] = "default"
+		return &csi.CreateVolumeRequest{
+			Name:               "pvc-1",
+			VolumeCapabilities: capabilities,
+			Parameters:         parameters,
+			Secrets:            secrets,
+		}
+	}
+
+	createVolume := func(parameters map[string]string) (*csi.CreateVolumeResponse, error) {
+		return driver.CreateVolume(context.Background(), createVolumeRequest(parameters))
+	}
+
+	Describe("CreateVolume", func() {
+		volumeID := func(bucket string) string {
+			return util.NewVolumeID("project", bucket, "").String()
+		}
+
+		Context("with --bucket-name-template", func() {
+			BeforeEach(func() {
+				options.ClusterID = "prod"
+				options.BucketNameTemplate = "{{ .ClusterID }}-{{ .Namespace }}-{{ .PVCName }}"
+			})
+
+			It("should render the bucket name from the template", func() {
+				bucket := util.GenerateBucketName("prod-default-data", "pvc-1", 0)
+
+				resp, err := createVolume(map[string]string{})
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(resp.Volume.VolumeId).To(Equal(volumeID(bucket)))
+				for key, value := range util.BucketOwnerLabels(CSIDriverName, "pvc-1") {
+					Expect(gcs.Label(bucket, key)).To(Equal(value))
+				}
+			})
+		})
+
+		It("should try another generated name if the bucket belongs to another volume", func() {
+			gcs.AddBucket(util.GenerateBucketName("shared", "pvc-1", 0), util.BucketOwnerLabels(CSIDriverName, "pvc-2"))
+
+			resp, err := createVolume(map[string]string{"gcs.csi.ofek.dev/bucket-name-template": "shared"})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp.Volume.VolumeId).To(Equal(volumeID(util.GenerateBucketName("shared", "pvc-1", 1))))
+		})
+
+		It("should reuse the bucket it generated on retries", func() {
+			first, err := createVolume(map[string]string{})
+			Expect(err).ShouldNot(HaveOccurred())
+			second, err := createVolume(map[string]string{})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(second.Volume.VolumeId).To(Equal(first.Volume.VolumeId))
+			Expect(gcs.creates).To(Equal(1))
+		})
+
+		Describe("Topology", func() {
+			regions := func(regions ...string) []*csi.Topology {
+				var topologies []*csi.Topology
+				for _, region := range regions {
+					topologies = append(topologies, &csi.Topology{Segments: map[string]string{TopologyKeyRegion: region}})
+				}
+				return topologies
+			}
+
+			It("should create the bucket in the preferred region", func() {
+				req := createVolumeRequest(map[string]string{"gcs.csi.ofek.dev/bucket": "bucket"})
+				req.AccessibilityRequirements = &csi.TopologyRequirement{
+					Requisite: regions("us-east1", "europe-west1"),
+					Preferred: regions("europe-west1"),
+				}
+
+				resp, err := driver.CreateVolume(context.Background(), req)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(gcs.Bucket("bucket")["location"]).To(Equal("EUROPE-WEST1"))
+				Expect(resp.Volume.AccessibleTopology).To(Equal(regions("europe-west1")))
+			})
+
+			It("should prefer the location option", func() {
+				req := createVolumeRequest(map[string]string{"gcs.csi.ofek.dev/bucket": "bucket", "gcs.csi.ofek.dev/location": "us"})
+				req.AccessibilityRequirements = &csi.TopologyRequirement{Preferred: regions("europe-west1")}
+
+				resp, err := driver.CreateVolume(context.Background(), req)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(gcs.Bucket("bucket")["location"]).To(Equal("US"))
+				Expect(resp.Volume.AccessibleTopology).To(BeEmpty())
+			})
+
+			It("should not restrict existing multi-region buckets to a region", func() {
+				gcs.AddBucket("bucket", util.BucketOwnerLabels(CSIDriverName, "pvc-1"))
+				req := createVolumeRequest(map[string]string{"gcs.csi.ofek.dev/bucket": "bucket"})
+				req.AccessibilityRequirements = &csi.TopologyRequirement{Preferred: regions("europe-west1")}
+
+				resp, err := driver.CreateVolume(context.Background(), req)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(resp.Volume.AccessibleTopology).To(BeEmpty())
+			})
+		})
+
+		Describe("Capacity budgets", func() {
+			const gi = 1 << 30
+
+			BeforeEach(func() {
+				options.CapacityBudgets = map[string]int64{"project": 10 * gi}
+				labels := util.BucketOwnerLabels(CSIDriverName, "pvc-2")
+				labels["capacity"] = "8589934592"
+				gcs.AddBucket("other", labels)
+			})
+
+			createVolume := func(parameters map[string]string, capacity int64) error {
+				req := createVolumeRequest(parameters)
+				req.CapacityRange = &csi.CapacityRange{RequiredBytes: capacity}
+				_, err := driver.CreateVolume(context.Background(), req)
+				return err
+			}
+
+			It("should provision volumes within the budget of the project", func() {
+				Expect(createVolume(map[string]string{}, 2*gi)).To(Succeed())
+			})
+
+			It("should refuse volumes exceeding the budget of the project", func() {
+				Expect(status.Code(createVolume(map[string]string{}, 4*gi))).To(Equal(codes.ResourceExhausted))
+				Expect(gcs.creates).To(Equal(0))
+			})
+
+			It("should prefer the budget of the StorageClass", func() {
+				Expect(createVolume(map[string]string{"gcs.csi.ofek.dev/capacity-budget": "12Gi"}, 4*gi)).To(Succeed())
+			})
+
+			It("should not count the bucket of the volume on retries", func() {
+				Expect(createVolume(map[string]string{}, 2*gi)).To(Succeed())
+				Expect(createVolume(map[string]string{}, 2*gi)).To(Succeed())
+			})
+		})
+
+		Describe("Annotation policy", func() {
+			var recorder *record.FakeRecorder
+
+			JustBeforeEach(func() {
+				recorder = record.NewFakeRecorder(1)
+				driver.SetEventRecorder(recorder)
+			})
+
+			annotate := func(annotations map[string]string) {
+				pvc, err := clientset.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), "data", metav1.GetOptions{})
+				Expect(err).ShouldNot(HaveOccurred())
+				pvc.Annotations = annotations
+				_, err = clientset.CoreV1().PersistentVolumeClaims("default").Update(context.Background(), pvc, metav1.UpdateOptions{})
+				Expect(err).ShouldNot(HaveOccurred())
+			}
+
+			It("should let annotations set options by default", func() {
+				annotate(map[string]string{"gcs.csi.ofek.dev/bucket": "custom"})
+
+				resp, err := createVolume(map[string]string{})
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(resp.Volume.VolumeId).To(Equal(volumeID("custom")))
+			})
+
+			Context("with --locked-options", func() {
+				BeforeEach(func() {
+					options.LockedOptions = []string{"location"}
+				})
+
+				It("should deny annotations setting locked options", func() {
+					annotate(map[string]string{"gcs.csi.ofek.dev/location": "eu"})
+
+					_, err := createVolume(map[string]string{})
+					Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+					Expect(recorder.Events).To(Receive(HavePrefix("Warning AnnotationDenied ")))
+					Expect(gcs.creates).To(Equal(0))
+				})
+			})
+
+			Context("with --allowed-annotations", func() {
+				BeforeEach(func() {
+					var err error
+					options.AnnotationPolicy, err = flags.ParseAnnotationPolicy("location=eu|us")
+					Expect(err).ShouldNot(HaveOccurred())
+				})
+
+				It("should only allow the annotations and values of the policy", func() {
+					annotate(map[string]string{"gcs.csi.ofek.dev/location": "asia"})
+					_, err := createVolume(map[string]string{"gcs.csi.ofek.dev/bucket": "bucket"})
+					Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+					annotate(map[string]string{"gcs.csi.ofek.dev/location": "eu"})
+					Expect(createVolume(map[string]string{"gcs.csi.ofek.dev/bucket": "bucket"})).NotTo(BeNil())
+					Expect(gcs.Bucket("bucket")["location"]).To(Equal("EU"))
+				})
+
+				It("should prefer the policy of the StorageClass", func() {
+					annotate(map[string]string{"gcs.csi.ofek.dev/bucket": "custom"})
+
+					_, err := createVolume(map[string]string{})
+					Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+					resp, err := createVolume(map[string]string{"gcs.csi.ofek.dev/allowed-annotations": "bucket"})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(resp.Volume.VolumeId).To(Equal(volumeID("custom")))
+				})
+			})
+
+			It("should reject invalid policies", func() {
+				_, err := createVolume(map[string]string{"gcs.csi.ofek.dev/allowed-annotations": "location=[("})
+				Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			})
+		})
+
+		It("should abort operations on a volume in progress", func() {
+			blocked, release := gcs.Block()
+			done := make(chan error)
+			go func() {
+				_, err := createVolume(map[string]string{})
+				done <- err
+			}()
+			<-blocked
+
+			_, err := createVolume(map[string]string{})
+			Expect(status.Code(err)).To(Equal(codes.Aborted))
+
+			release()
+			Expect(<-done).To(Succeed())
+			Expect(createVolume(map[string]string{})).NotTo(BeNil())
+		})
+
+		It("should reject invalid locked options", func() {
+			_, err := createVolume(map[string]string{"gcs.csi.ofek.dev/locked-options": "bucket,unknown"})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			Expect(err.Error()).To(ContainSubstring(`cannot lock unknown option "unknown"`))
+		})
+
+		It("should reject invalid templates", func() {
+			_, err := createVolume(map[string]string{"gcs.csi.ofek.dev/bucket-name-template": "{{ .Unknown }}"})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			Expect(gcs.creates).To(Equal(0))
+		})
+
+		It("should not create a bucket it can't look up", func() {
+			gcs.denied["bucket"] = true
+
+			_, err := createVolume(map[string]string{"gcs.csi.ofek.dev/bucket": "bucket"})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(gcs.creates).To(Equal(0))
+		})
+
+		It("should try another generated name if the bucket can't be looked up", func() {
+			gcs.denied[util.GenerateBucketName("shared", "pvc-1", 0)] = true
+
+			resp, err := createVolume(map[string]string{"gcs.csi.ofek.dev/bucket-name-template": "shared"})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp.Volume.VolumeId).To(HaveSuffix(util.GenerateBucketName("shared", "pvc-1", 1)))
+			Expect(gcs.creates).To(Equal(1))
+		})
+	})
+
+	Describe("DeleteVolume", func() {
+		deleteVolume := func(volumeID string) error {
+			_, err := driver.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID, Secrets: secrets})
+			return err
+		}
+
+		It("should delete buckets it provisioned", func() {
+			gcs.AddBucket("bucket", util.BucketOwnerLabels(CSIDriverName, "pvc-1"))
+
+			Expect(deleteVolume("v1/project/bucket")).To(Succeed())
+			Expect(gcs.Bucket("bucket")).To(BeNil())
+		})
+
+		It("should refuse to delete buckets it didn't provision", func() {
+			gcs.AddBucket("bucket", nil)
+
+			Expect(status.Code(deleteVolume("bucket"))).To(Equal(codes.FailedPrecondition))
+			Expect(gcs.Bucket("bucket")).NotTo(BeNil())
+		})
+
+		It("should succeed if the bucket is gone", func() {
+			Expect(deleteVolume("v1/project/bucket")).To(Succeed())
+		})
+
+		Context("with --delete-unowned-buckets", func() {
+			BeforeEach(func() {
+				options.DeleteUnownedBuckets = true
+			})
+
+			It("should delete buckets it didn't provision", func() {
+				gcs.AddBucket("bucket", nil)
+
+				Expect(deleteVolume("bucket")).To(Succeed())
+				Expect(gcs.Bucket("bucket")).To(BeNil())
+			})
+		})
+
+		Describe("Prefix volumes", func() {
+			BeforeEach(func() {
+				gcs.AddBucket("shared", nil)
+				gcs.AddObject("shared", "other/file", nil)
+			})
+
+			It("should delete prefixes it provisioned", func() {
+				gcs.AddObject("shared", "pvc-1/", util.BucketOwnerLabels(CSIDriverName, "pvc-1"))
+				gcs.AddObject("shared", "pvc-1/file", nil)
+
+				Expect(deleteVolume("v1/project/shared/pvc-1")).To(Succeed())
+				Expect(gcs.Object("shared", "pvc-1/")).To(BeNil())
+				Expect(gcs.Object("shared", "pvc-1/file")).To(BeNil())
+				Expect(gcs.Object("shared", "other/file")).NotTo(BeNil())
+			})
+
+			It("should refuse to delete prefixes it didn't provision", func() {
+				gcs.AddObject("shared", "pvc-1/", nil)
+
+				Expect(status.Code(deleteVolume("v1/project/shared/pvc-1"))).To(Equal(codes.FailedPrecondition))
+				Expect(gcs.Object("shared", "pvc-1/")).NotTo(BeNil())
+			})
+
+			It("should succeed if the prefix is gone", func() {
+				Expect(deleteVolume("v1/project/shared/pvc-1")).To(Succeed())
+				Expect(gcs.Object("shared", "other/file")).NotTo(BeNil())
+			})
+
+			It("should succeed if the bucket is gone", func() {
+				Expect(deleteVolume("v1/project/bucket/pvc-1")).To(Succeed())
+			})
+
+			Context("with --delete-unowned-buckets", func() {
+				BeforeEach(func() {
+					options.DeleteUnownedBuckets = true
+				})
+
+				It("should delete prefixes it didn't provision", func() {
+					gcs.AddObject("shared", "pvc-1/file", nil)
+
+					Expect(deleteVolume("v1/project/shared/pvc-1")).To(Succeed())
+					Expect(gcs.Object("shared", "pvc-1/file")).To(BeNil())
+					Expect(gcs.Object("shared", "other/file")).NotTo(BeNil())
+				})
+
+				It("should succeed if the bucket is gone", func() {
+					Expect(deleteVolume("v1/project/bucket/pvc-1")).To(Succeed())
+				})
+			})
+		})
+	})
+
+	Describe("GetCapacity", func() {
+		getCapacity := func(parameters map[string]string) (int64, error) {
+			resp, err := driver.GetCapacity(context.Background(), &csi.GetCapacityRequest{Parameters: parameters})
+			if err != nil {
+				return 0, err
+			}
+			return resp.AvailableCapacity, nil
+		}
+
+		It("should report unlimited capacity without a budget", func() {
+			Expect(getCapacity(map[string]string{"gcs.csi.ofek.dev/project-id": "project"})).To(BeEquivalentTo(math.MaxInt64))
+		})
+
+		Context("with --capacity-budgets", func() {
+			var credentials string
+
+			BeforeEach(func() {
+				options.CapacityBudgets = map[string]int64{"project": 10}
+				labels := util.BucketOwnerLabels(CSIDriverName, "pvc-1")
+				labels["capacity"] = "4"
+				gcs.AddBucket("bucket", labels)
+				gcs.AddBucket("unowned", map[string]string{"capacity": "4"})
+
+				// The default credentials are only read, the fake storage doesn't check them
+				file, err := ioutil.TempFile("", "credentials")
+				Expect(err).ShouldNot(HaveOccurred())
+				fmt.Fprint(file, `{"type": "authorized_user", "client_id": "id", "client_secret": "secret", "refresh_token": "token"}`)
+				file.Close()
+				credentials = file.Name()
+				os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", credentials)
+			})
+
+			AfterEach(func() {
+				os.Unsetenv("GOOGLE_APPLICATION_CREDENTIALS")
+				os.Remove(credentials)
+			})
+
+			It("should report the capacity left in the budget of the project", func() {
+				Expect(getCapacity(map[string]string{"gcs.csi.ofek.dev/project-id": "project"})).To(BeEquivalentTo(6))
+			})
+
+			It("should prefer the budget of the StorageClass", func() {
+				Expect(getCapacity(map[string]string{"gcs.csi.ofek.dev/project-id": "project", "gcs.csi.ofek.dev/capacity-budget": "12"})).To(BeEquivalentTo(8))
+			})
+		})
+
+		It("should require the project to compute the capacity", func() {
+			_, err := getCapacity(map[string]string{"gcs.csi.ofek.dev/capacity-budget": "10"})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		})
+	})
+
+	Describe("ValidateVolumeCapabilities", func() {
+		validate := func() error {
+			_, err := driver.ValidateVolumeCapabilities(context.Background(), &csi.ValidateVolumeCapabilitiesRequest{
+				VolumeId:           "v1/project/bucket",
+				VolumeCapabilities: capabilities,
+				Secrets:            secrets,
+			})
+			return err
+		}
+
+		It("should only report missing buckets as missing volumes", func() {
+			Expect(status.Code(validate())).To(Equal(codes.NotFound))
+
+			gcs.denied["bucket"] = true
+			Expect(status.Code(validate())).To(Equal(codes.PermissionDenied))
+		})
+	})
+})
@@ -4,36 +4,96 @@ import (
 	"context"
 	"errors"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
-	"k8s.io/klog"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/mount"
 
+	gcs "github.com/ofek/csi-gcs/pkg/client/clientset/clientset"
+	"github.com/ofek/csi-gcs/pkg/flags"
 	"github.com/ofek/csi-gcs/pkg/util"
-
-	"k8s.io/utils/mount"
 )
 
 type GCSDriver struct {
-	name               string
-	nodeName           string
-	endpoint           string
-	mountPoint         string
-	version            string
-	server             *grpc.Server
-	mounter            mount.Interface
-	deleteOrphanedPods bool
+	name                 string
+	nodeName             string
+	endpoint             string
+	mountPoint           string
+	version              string
+	server               *grpc.Server
+	mounter              util.ContextMounter
+	deleteOrphanedPods   bool
+	deleteUnownedBuckets bool
+	clusterID            string
+	bucketNameTemplate   string
+	capacityBudgets      map[string]int64
+	lockedOptions        []string
+	annotationPolicy     flags.AnnotationPolicy
+	enforceBucketAccess  bool
+	mountTimeout         time.Duration
+	volumeLocks          *util.VolumeLocks
+	forwardGcsfuseLogs   bool
+	logFollowers         map[string]context.CancelFunc
+	logFollowersMutex    sync.Mutex
+	recorder             record.EventRecorder
+	clientset            kubernetes.Interface
+	gcsClientset         gcs.Interface
+	gcsfuseConfigFile    bool
+}
+
+// GCSDriverOptions holds the optional settings of the driver.
+type GCSDriverOptions struct {
+	// DeleteOrphanedPods deletes pods whose volumes are no longer mounted on startup.
+	DeleteOrphanedPods bool
+	// DeleteUnownedBuckets allows DeleteVolume to delete buckets that lack the driver's ownership labels.
+	DeleteUnownedBuckets bool
+	// ClusterID identifies the cluster in bucket name templates.
+	ClusterID string
+	// BucketNameTemplate is the default template for the names of provisioned buckets.
+	BucketNameTemplate string
+	// CapacityBudgets limits the total capacity of the buckets the driver may provision per project.
+	CapacityBudgets map[string]int64
+	// LockedOptions are the options that PersistentVolumeClaim annotations may not set.
+	LockedOptions []string
+	// AnnotationPolicy restricts the options PersistentVolumeClaim annotations may set, nil allows all of them.
+	AnnotationPolicy flags.AnnotationPolicy
+	// EnforceBucketAccess restricts the buckets pods may mount to those allowed by BucketAccessPolicy resources.
+	EnforceBucketAccess bool
+	// MountTimeout limits how long mounting a bucket may take, 0 only limits it by the deadline of the request.
+	MountTimeout time.Duration
+	// ForwardGcsfuseLogs logs the lines gcsfuse logs for every mount, tagged with the volume and pod.
+	ForwardGcsfuseLogs bool
 }
 
-func NewGCSDriver(name, node, endpoint string, version string, deleteOrphanedPods bool) (*GCSDriver, error) {
+// NewGCSDriver returns a driver using the clientsets for all requests to the Kubernetes API.
+func NewGCSDriver(name, node, endpoint string, version string, clientset kubernetes.Interface, gcsClientset gcs.Interface, options GCSDriverOptions) (*GCSDriver, error) {
 	return &GCSDriver{
-		name:               name,
-		nodeName:           node,
-		endpoint:           endpoint,
-		mountPoint:         BucketMountPath,
-		version:            version,
-		mounter:            mount.New(""),
-		deleteOrphanedPods: deleteOrphanedPods,
+		name:                 name,
+		nodeName:             node,
+		endpoint:             endpoint,
+		mountPoint:           BucketMountPath,
+		version:              version,
+		mounter:              util.NewContextMounter(mount.New(""), "mount"),
+		deleteOrphanedPods:   options.DeleteOrphanedPods,
+		deleteUnownedBuckets: options.DeleteUnownedBuckets,
+		clusterID:            options.ClusterID,
+		bucketNameTemplate:   options.BucketNameTemplate,
+		capacityBudgets:      options.CapacityBudgets,
+		lockedOptions:        options.LockedOptions,
+		annotationPolicy:     options.AnnotationPolicy,
+		enforceBucketAccess:  options.EnforceBucketAccess,
+		mountTimeout:         options.MountTimeout,
+		volumeLocks:          util.NewVolumeLocks(),
+		forwardGcsfuseLogs:   options.ForwardGcsfuseLogs,
+		logFollowers:         map[string]context.CancelFunc{},
+		clientset:            clientset,
+		gcsClientset:         gcsClientset,
 	}, nil
 }
 
@@ -41,14 +101,16 @@ func (d *GCSDriver) Run() error {
 	ctx := context.TODO()
 
 	// set the driver-ready label to false at the beginning to handle edge-case where the controller didn't terminated gracefully
-	if err := util.SetDriverReadyLabel(ctx, d.name, d.nodeName, false); err != nil {
-		klog.Warningf("Unable to set driver-ready=false label on the node, error: %v", err)
+	if err := util.SetDriverReadyLabel(ctx, d.clientset, d.name, d.nodeName, false); err != nil {
+		klog.ErrorS(err, "Unable to set driver-ready=false label on the node")
 	}
 
 	if len(d.mountPoint) == 0 {
 		return errors.New("--bucket-mount-path is required")
 	}
 
+	d.recorder = util.NewEventRecorder(d.clientset, d.name, d.nodeName)
+
 	scheme, address, err := util.ParseEndpoint(d.endpoint)
 	if err != nil {
 		return err
@@ -59,31 +121,29 @@ func (d *GCSDriver) Run() error {
 		return err
 	}
 
-	logHandler := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		resp, err := handler(ctx, req)
-		if err == nil {
-			klog.V(4).Infof("Method %s completed", info.FullMethod)
-		} else {
-			klog.Errorf("Method %s failed with error: %v", info.FullMethod, err)
-		}
-		return resp, err
+	major, minor, err := util.GcsfuseVersion(ctx)
+	if err != nil {
+		klog.ErrorS(err, "Unable to detect the gcsfuse version, falling back to mount options")
+	} else {
+		klog.V(1).InfoS("Detected gcsfuse version", "major", major, "minor", minor)
+		d.gcsfuseConfigFile = major >= GcsfuseConfigFileMajorVersion
 	}
 
 	if d.deleteOrphanedPods {
 		err = d.RunPodCleanup()
 
 		if err != nil {
-			klog.Errorf("RunPodCleanup failed with error: %v", err)
+			klog.ErrorS(err, "RunPodCleanup failed")
 		}
 	}
 
-	klog.V(1).Infof("Starting Google Cloud Storage CSI Driver - driver: `%s`, version: `%s`, gRPC socket: `%s`", d.name, d.version, d.endpoint)
-	d.server = grpc.NewServer(grpc.UnaryInterceptor(logHandler))
+	klog.V(1).InfoS("Starting Google Cloud Storage CSI Driver", "driver", d.name, "version", d.version, "endpoint", d.endpoint)
+	d.server = grpc.NewServer(grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor(), logInterceptor))
 	csi.RegisterIdentityServer(d.server, d)
 	csi.RegisterNodeServer(d.server, d)
 	csi.RegisterControllerServer(d.server, d)
-	if err = util.SetDriverReadyLabel(ctx, d.name, d.nodeName, true); err != nil {
-		klog.Warningf("unable to set driver-ready=true label on the node, error: %v", err)
+	if err = util.SetDriverReadyLabel(ctx, d.clientset, d.name, d.nodeName, true); err != nil {
+		klog.ErrorS(err, "Unable to set driver-ready=true label on the node")
 	}
 	return d.server.Serve(listener)
 }
@@ -92,27 +152,27 @@ func (d *GCSDriver) stop() {
 	ctx := context.TODO()
 
 	d.server.Stop()
-	if err := util.SetDriverReadyLabel(ctx, d.name, d.nodeName, false); err != nil {
-		klog.Warningf("Unable to set driver-ready=false label on the node, error: %v", err)
+	if err := util.SetDriverReadyLabel(ctx, d.clientset, d.name, d.nodeName, false); err != nil {
+		klog.ErrorS(err, "Unable to set driver-ready=false label on the node")
 	}
-	klog.V(1).Info("CSI driver stopped")
+	klog.V(1).InfoS("CSI driver stopped")
 }
 
 func (d *GCSDriver) RunPodCleanup() (err error) {
 	ctx := context.TODO()
 
-	publishedVolumes, err := util.GetRegisteredMounts(ctx, d.nodeName)
+	publishedVolumes, err := util.GetRegisteredMounts(ctx, d.gcsClientset, d.nodeName)
 	if err != nil {
 		return err
 	}
 
 	for _, publishedVolume := range publishedVolumes.Items {
 		// Killing Pod because its Volume is no longer mounted
-		err = util.DeletePod(ctx, publishedVolume.Spec.Pod.Namespace, publishedVolume.Spec.Pod.Name)
+		err = util.DeletePod(ctx, d.clientset, publishedVolume.Spec.Pod.Namespace, publishedVolume.Spec.Pod.Name)
 		if err == nil {
-			klog.V(4).Infof("Deleted Pod %s/%s because its volume was no longer mounted", publishedVolume.Spec.Pod.Namespace, publishedVolume.Spec.Pod.Name)
+			klog.V(4).InfoS("Deleted pod because its volume was no longer mounted", "pod", klog.KRef(publishedVolume.Spec.Pod.Namespace, publishedVolume.Spec.Pod.Name))
 		} else {
-			klog.Errorf("Could not delete pod %s/%s because it was no longer mounted because of error: %v", publishedVolume.Spec.Pod.Namespace, publishedVolume.Spec.Pod.Name, err)
+			klog.ErrorS(err, "Could not delete pod whose volume was no longer mounted", "pod", klog.KRef(publishedVolume.Spec.Pod.Namespace, publishedVolume.Spec.Pod.Name))
 		}
 	}
 
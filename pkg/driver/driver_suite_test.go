@@ -3,11 +3,32 @@ package driver_test
 import (
 	"testing"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"k8s.io/client-go/kubernetes"
+
+	gcs "github.com/ofek/csi-gcs/pkg/client/clientset/clientset"
+	. "github.com/ofek/csi-gcs/pkg/driver"
 )
 
 func TestDriver(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Driver Suite")
 }
+
+// newDriver returns a driver on the node `node` using the clientsets, which are usually fake ones.
+func newDriver(clientset kubernetes.Interface, gcsClientset gcs.Interface, options GCSDriverOptions) *GCSDriver {
+	driver, err := NewGCSDriver(CSIDriverName, "node", "unix:///tmp/csi.sock", "test", clientset, gcsClientset, options)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	return driver
+}
+
+// mountCapability returns the capability of a mounted volume with the access mode.
+func mountCapability(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+	}
+}
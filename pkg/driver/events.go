@@ -0,0 +1,79 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	"github.com/ofek/csi-gcs/pkg/util"
+)
+
+// Reasons of the warning events recorded on PersistentVolumeClaims and pods
+const (
+	EventReasonAnnotationDenied   = "AnnotationDenied"
+	EventReasonCredentialsInvalid = "CredentialsInvalid"
+	EventReasonBucketCreateFailed = "BucketCreateFailed"
+	EventReasonPrefixCreateFailed = "PrefixCreateFailed"
+	EventReasonBucketNotFound     = "BucketNotFound"
+	EventReasonMountFailed        = "MountFailed"
+)
+
+// eventReason returns the reason of an event for the error, Cloud Storage rejecting the credentials takes
+// precedence over the given reason.
+func eventReason(err error, reason string) string {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized {
+		return EventReasonCredentialsInvalid
+	}
+	if status.Code(err) == codes.Unauthenticated {
+		return EventReasonCredentialsInvalid
+	}
+
+	return reason
+}
+
+// recordPvcEvent records a warning event on the PersistentVolumeClaim of the volume being provisioned, if the
+// provisioner passed it.
+func (d *GCSDriver) recordPvcEvent(ctx context.Context, req *csi.CreateVolumeRequest, reason string, err error) {
+	name := req.Parameters["csi.storage.k8s.io/pvc/name"]
+	namespace := req.Parameters["csi.storage.k8s.io/pvc/namespace"]
+	if d.recorder == nil || name == "" || namespace == "" {
+		return
+	}
+
+	// The event must reference the UID for kubectl describe to show it
+	pvc, getErr := util.GetPvc(ctx, d.clientset, name, namespace)
+	if getErr != nil {
+		klog.FromContext(ctx).Error(getErr, "Unable to record event on PersistentVolumeClaim", "pvc", klog.KRef(namespace, name), "reason", reason)
+		return
+	}
+
+	d.recorder.Event(pvc, corev1.EventTypeWarning, eventReason(err, reason), status.Convert(err).Message())
+}
+
+// recordPodEvent records a warning event on the pod the volume is published to, if the kubelet passed it, see
+// podInfoOnMount.
+func (d *GCSDriver) recordPodEvent(req *csi.NodePublishVolumeRequest, reason string, err error) {
+	name := req.VolumeContext[VolumeContextPodName]
+	uid := req.VolumeContext[VolumeContextPodUID]
+	if d.recorder == nil || name == "" || uid == "" {
+		return
+	}
+
+	pod := &corev1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Namespace:  req.VolumeContext[VolumeContextPodNamespace],
+		Name:       name,
+		UID:        types.UID(uid),
+	}
+	d.recorder.Event(pod, corev1.EventTypeWarning, eventReason(err, reason), status.Convert(err).Message())
+}
@@ -0,0 +1,36 @@
+package driver
+
+import (
+	"context"
+
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/mount"
+
+	"github.com/ofek/csi-gcs/pkg/util"
+)
+
+// SetMounter replaces the mounter, allowing tests to observe and block mount operations. Mounters that can't mount
+// under a context mount with Mount, ignoring the context.
+func (d *GCSDriver) SetMounter(mounter mount.Interface) {
+	if contextMounter, ok := mounter.(util.ContextMounter); ok {
+		d.mounter = contextMounter
+	} else {
+		d.mounter = &mounterWithoutContext{Interface: mounter}
+	}
+}
+
+type mounterWithoutContext struct {
+	mount.Interface
+}
+
+func (m *mounterWithoutContext) MountContext(ctx context.Context, source string, target string, fstype string, options []string) error {
+	return m.Mount(source, target, fstype, options)
+}
+
+// WithRequestLogger exposes the logger attached to the context of every request.
+var WithRequestLogger = withRequestLogger
+
+// SetEventRecorder replaces the event recorder, allowing tests to observe events.
+func (d *GCSDriver) SetEventRecorder(recorder record.EventRecorder) {
+	d.recorder = recorder
+}
@@ -4,12 +4,9 @@ import (
 	"context"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	"k8s.io/klog"
 )
 
 func (d *GCSDriver) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
-	klog.V(4).Infof("Method GetPluginInfo called with: %+v", req)
-
 	return &csi.GetPluginInfoResponse{
 		Name:          d.name,
 		VendorVersion: driverVersion,
@@ -17,8 +14,6 @@ func (d *GCSDriver) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoReq
 }
 
 func (d *GCSDriver) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
-	klog.V(4).Infof("Method GetPluginCapabilities called with: %+v", req)
-
 	return &csi.GetPluginCapabilitiesResponse{
 		Capabilities: []*csi.PluginCapability{
 			{
@@ -28,12 +23,17 @@ func (d *GCSDriver) GetPluginCapabilities(ctx context.Context, req *csi.GetPlugi
 					},
 				},
 			},
+			{
+				Type: &csi.PluginCapability_Service_{
+					Service: &csi.PluginCapability_Service{
+						Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+					},
+				},
+			},
 		},
 	}, nil
 }
 
 func (d *GCSDriver) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
-	klog.V(4).Infof("Method Probe called with: %+v", req)
-
 	return &csi.ProbeResponse{}, nil
 }
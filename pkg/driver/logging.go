@@ -0,0 +1,63 @@
+package driver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"k8s.io/klog/v2"
+)
+
+// logInterceptor attaches a logger to the context of every request, so that every line logged while handling the
+// request can be correlated with it, and logs the request and its outcome.
+func logInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx = withRequestLogger(ctx, info.FullMethod, req)
+	logger := klog.FromContext(ctx)
+
+	logger.V(4).Info("Method called", "request", protosanitizer.StripSecrets(req))
+	resp, err := handler(ctx, req)
+	if err == nil {
+		logger.V(4).Info("Method completed")
+	} else {
+		logger.Error(err, "Method failed")
+	}
+
+	return resp, err
+}
+
+// withRequestLogger returns a context whose logger tags every line with a new request ID, the method and, if the
+// request has them, the volume ID, target path, pod and trace ID.
+func withRequestLogger(ctx context.Context, method string, req interface{}) context.Context {
+	keysAndValues := []interface{}{"requestID", newRequestID(), "method", method}
+
+	if r, ok := req.(interface{ GetVolumeId() string }); ok && r.GetVolumeId() != "" {
+		keysAndValues = append(keysAndValues, "volumeID", r.GetVolumeId())
+	}
+	if r, ok := req.(interface{ GetTargetPath() string }); ok && r.GetTargetPath() != "" {
+		keysAndValues = append(keysAndValues, "targetPath", r.GetTargetPath())
+	}
+	if r, ok := req.(interface{ GetVolumeContext() map[string]string }); ok {
+		volumeContext := r.GetVolumeContext()
+		if name := volumeContext[VolumeContextPodName]; name != "" {
+			keysAndValues = append(keysAndValues, "pod", klog.KRef(volumeContext[VolumeContextPodNamespace], name))
+		}
+	}
+
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		keysAndValues = append(keysAndValues, "traceID", spanContext.TraceID().String())
+	}
+
+	return klog.NewContext(ctx, klog.FromContext(ctx).WithValues(keysAndValues...))
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "unknown"
+	}
+
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,65 @@
+package driver_test
+
+import (
+	"context"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/go-logr/logr/funcr"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/klog/v2"
+
+	. "github.com/ofek/csi-gcs/pkg/driver"
+)
+
+var _ = Describe("Logging", func() {
+	var lines []string
+
+	BeforeEach(func() {
+		lines = nil
+		klog.SetLogger(funcr.NewJSON(func(obj string) {
+			lines = append(lines, obj)
+		}, funcr.Options{}))
+	})
+
+	AfterEach(func() {
+		klog.ClearLogger()
+	})
+
+	It("Should tag lines with the request", func() {
+		ctx := WithRequestLogger(context.Background(), "/csi.v1.Node/NodePublishVolume", &csi.NodePublishVolumeRequest{
+			VolumeId:   "bucket",
+			TargetPath: "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv/mount",
+			VolumeContext: map[string]string{
+				VolumeContextPodName:      "app",
+				VolumeContextPodNamespace: "default",
+			},
+		})
+		klog.FromContext(ctx).Info("test")
+
+		Expect(lines).To(HaveLen(1))
+		Expect(lines[0]).To(MatchRegexp(`"requestID":"[0-9a-f]{16}"`))
+		Expect(lines[0]).To(ContainSubstring(`"method":"/csi.v1.Node/NodePublishVolume"`))
+		Expect(lines[0]).To(ContainSubstring(`"volumeID":"bucket"`))
+		Expect(lines[0]).To(ContainSubstring(`"targetPath":"/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv/mount"`))
+		Expect(lines[0]).To(ContainSubstring(`"pod":{"name":"app","namespace":"default"}`))
+	})
+
+	It("Should only tag lines with what the request has", func() {
+		ctx := WithRequestLogger(context.Background(), "/csi.v1.Identity/Probe", &csi.ProbeRequest{})
+		klog.FromContext(ctx).Info("test")
+
+		Expect(lines).To(HaveLen(1))
+		Expect(lines[0]).To(ContainSubstring(`"method":"/csi.v1.Identity/Probe"`))
+		Expect(lines[0]).NotTo(ContainSubstring(`"volumeID"`))
+		Expect(lines[0]).NotTo(ContainSubstring(`"pod"`))
+	})
+
+	It("Should use a new request ID for every request", func() {
+		klog.FromContext(WithRequestLogger(context.Background(), "/csi.v1.Identity/Probe", &csi.ProbeRequest{})).Info("test")
+		klog.FromContext(WithRequestLogger(context.Background(), "/csi.v1.Identity/Probe", &csi.ProbeRequest{})).Info("test")
+
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[0]).NotTo(Equal(lines[1]))
+	})
+})
@@ -2,27 +2,25 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"github.com/ofek/csi-gcs/pkg/flags"
 	"github.com/ofek/csi-gcs/pkg/util"
-	"golang.org/x/oauth2/google"
 	"google.golang.org/api/option"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"k8s.io/klog"
+	"k8s.io/klog/v2"
 	"k8s.io/utils/mount"
 )
 
-func (driver *GCSDriver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
-	klog.V(4).Infof("Method NodePublishVolume called with: %s", protosanitizer.StripSecrets(req))
-
+func (driver *GCSDriver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (response *csi.NodePublishVolumeResponse, err error) {
 	if req.GetVolumeId() == "" {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
 	}
@@ -35,35 +33,124 @@ func (driver *GCSDriver) NodePublishVolume(ctx context.Context, req *csi.NodePub
 		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume Volume Capability must be provided")
 	}
 
-	if req.VolumeCapability.GetMount() == nil || req.VolumeCapability.GetBlock() != nil {
-		return nil, status.Error(codes.InvalidArgument, "Only volumeMode Filesystem is supported")
+	if !driver.volumeLocks.TryAcquire(req.VolumeId, req.TargetPath) {
+		return nil, status.Errorf(codes.Aborted, "An operation on volume %s or target path %s is already in progress", req.VolumeId, req.TargetPath)
+	}
+	defer driver.volumeLocks.Release(req.VolumeId, req.TargetPath)
+
+	if err := util.ValidateVolumeCapabilities([]*csi.VolumeCapability{req.VolumeCapability}); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Reader access modes are enforced by mounting read-only
+	accessMode := req.VolumeCapability.GetAccessMode().GetMode()
+	readOnly := req.GetReadonly() || util.IsReadOnlyAccessMode(accessMode)
+	singleWriter := util.IsSingleWriterAccessMode(accessMode) && !readOnly
+
+	// The IDs of inline volumes are generated by the kubelet, their bucket is a volume attribute
+	ephemeral := req.VolumeContext[VolumeContextEphemeral] == "true"
+	if ephemeral {
+		// Inline volumes are declared by the pod author, who must not borrow the credentials of the driver
+		if len(req.Secrets) == 0 && !driver.enforceBucketAccess {
+			return nil, status.Error(codes.PermissionDenied, "Inline volumes require credentials from nodePublishSecretRef unless bucket access policies are enforced")
+		}
+		// and who chooses the secret, so it may only set what volume attributes may
+		if err := flags.ValidateFlags(flags.SOURCE_INLINE_VOLUME, req.Secrets); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	// Merge options by precedence, see flags.Precedence
+	defaults := flags.Defaults(flags.SCOPE_NODE)
+	if !ephemeral {
+		volumeID, err := util.ParseVolumeID(req.GetVolumeId())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		defaults[flags.FLAG_BUCKET] = volumeID.Bucket
+		if volumeID.Prefix != "" {
+			defaults[flags.FLAG_ONLY_DIR] = volumeID.Prefix
+		}
+	}
+	merger := flags.NewMerger(ctx, defaults)
+
+	if err := merger.MergeVolumeMountGroup(req.VolumeCapability.GetMount().GetVolumeMountGroup()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	// Default Options
-	var options = map[string]string{
-		"bucket":   req.GetVolumeId(),
-		"gid":      strconv.FormatInt(DefaultGid, 10),
-		"dirMode":  "0" + strconv.FormatInt(DefaultDirMode, 8),
-		"fileMode": "0" + strconv.FormatInt(DefaultFileMode, 8),
+	if err := merger.Merge(flags.SOURCE_SECRET, req.Secrets); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	// Merge Secret Options
-	options = flags.MergeSecret(options, req.Secrets)
+	if err := merger.MergeMountOptions(req.GetVolumeCapability().GetMount().GetMountFlags()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
-	// Merge MountFlag Options
-	options = flags.MergeMountOptions(options, req.GetVolumeCapability().GetMount().GetMountFlags())
+	if ephemeral {
+		if err := merger.Merge(flags.SOURCE_INLINE_VOLUME, req.VolumeContext); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	} else if err := merger.MergeVolumeContext(req.VolumeContext); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if ownerFromPod, _ := strconv.ParseBool(merger.Options()[flags.FLAG_OWNER_FROM_POD]); ownerFromPod {
+		if err := driver.mergePodOwner(ctx, req, merger); err != nil {
+			return nil, err
+		}
+	}
+
+	options := merger.Options()
+
+	if options[flags.FLAG_BUCKET] == "" {
+		if ephemeral {
+			return nil, status.Error(codes.InvalidArgument, "Inline volumes require the bucket volume attribute")
+		}
+		return nil, status.Error(codes.InvalidArgument, "Bucket missing in request")
+	}
+
+	if _, err := flags.ParseOptions(options); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if driver.enforceBucketAccess {
+		podNamespace := req.VolumeContext[VolumeContextPodNamespace]
+		if podNamespace == "" {
+			return nil, status.Error(codes.PermissionDenied, "Pod namespace missing in volume context, bucket access policies require podInfoOnMount")
+		}
+
+		allowed, reason, err := util.CheckBucketAccess(ctx, driver.clientset, driver.gcsClientset, podNamespace, options[flags.FLAG_BUCKET], readOnly)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to check bucket access policies: %v", err)
+		}
+		if !allowed {
+			return nil, status.Error(codes.PermissionDenied, reason)
+		}
+	}
 
-	// Merge Volume Context
-	if req.VolumeContext != nil {
-		options = flags.MergeFlags(options, req.VolumeContext)
+	// Claim single writer volumes first so that publishing on other nodes at the same time is refused
+	if singleWriter {
+		if err := driver.claimVolumeWriter(ctx, req, merger, options); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err == nil {
+				return
+			}
+			if err := util.UnregisterMount(ctx, driver.gcsClientset, req.VolumeId, req.TargetPath, driver.nodeName); err != nil {
+				klog.FromContext(ctx).Error(err, "Error releasing the single writer claim")
+			}
+		}()
 	}
 
 	var clientOpt option.ClientOption
 	keyFile := ""
 	if len(req.Secrets) == 0 {
 		// Find default credentials
-		creds, err := google.FindDefaultCredentials(ctx, storage.ScopeReadOnly)
+		creds, err := util.FindDefaultCredentials(ctx, storage.ScopeReadOnly)
 		if err != nil {
+			driver.recordPodEvent(req, EventReasonCredentialsInvalid, err)
 			return nil, err
 		}
 		clientOpt = option.WithCredentials(creds)
@@ -72,6 +159,7 @@ func (driver *GCSDriver) NodePublishVolume(ctx context.Context, req *csi.NodePub
 		var err error
 		keyFile, err = util.GetKey(req.Secrets, KeyStoragePath)
 		if err != nil {
+			driver.recordPodEvent(req, EventReasonCredentialsInvalid, err)
 			return nil, err
 		}
 		clientOpt = option.WithCredentialsFile(keyFile)
@@ -80,7 +168,10 @@ func (driver *GCSDriver) NodePublishVolume(ctx context.Context, req *csi.NodePub
 	// Creates a client.
 	client, err := storage.NewClient(ctx, clientOpt)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to create client: %v", err)
+		util.CleanupKey(keyFile, KeyStoragePath)
+		err = status.Errorf(codes.Internal, "Failed to create client: %v", err)
+		driver.recordPodEvent(req, EventReasonCredentialsInvalid, err)
+		return nil, err
 	}
 
 	// Creates a Bucket instance.
@@ -88,10 +179,13 @@ func (driver *GCSDriver) NodePublishVolume(ctx context.Context, req *csi.NodePub
 
 	bucketExists, err := util.BucketExists(ctx, bucket)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to check if bucket exists: %v", err)
+		driver.recordPodEvent(req, eventReason(err, EventReasonMountFailed), err)
+		return nil, util.GCSErrorf(err, "Failed to check if bucket exists: %v", err)
 	}
 	if !bucketExists {
-		return nil, status.Errorf(codes.NotFound, "Bucket %s does not exist", options[flags.FLAG_BUCKET])
+		err = status.Errorf(codes.NotFound, "Bucket %s does not exist", options[flags.FLAG_BUCKET])
+		driver.recordPodEvent(req, EventReasonBucketNotFound, err)
+		return nil, err
 	}
 
 	notMnt, err := driver.mounter.IsLikelyNotMountPoint(req.TargetPath)
@@ -114,43 +208,225 @@ func (driver *GCSDriver) NodePublishVolume(ctx context.Context, req *csi.NodePub
 	if keyFile != "" {
 		mountOptions = append(mountOptions, fmt.Sprintf("key_file=%s", keyFile))
 	}
-	mountOptions = append(mountOptions, flags.ExtraFlags(options)...)
-	if req.GetReadonly() {
+	if driver.gcsfuseConfigFile {
+		config, err := flags.GcsfuseConfig(options)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to render gcsfuse config: %v", err)
+		}
+		configFile, err := util.WriteGcsfuseConfig(ConfigStoragePath, req.TargetPath, config)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to save gcsfuse config: %v", err)
+		}
+		mountOptions = append(mountOptions, fmt.Sprintf("config_file=%s", configFile))
+		mountOptions = append(mountOptions, flags.ConfigFileFlags(options)...)
+	} else {
+		mountOptions = append(mountOptions, flags.ExtraFlags(options)...)
+	}
+	if readOnly {
 		mountOptions = append(mountOptions, "ro")
 	}
 
-	err = driver.mounter.Mount(options[flags.FLAG_BUCKET], req.TargetPath, "gcsfuse", mountOptions)
+	logFile, err := driver.prepareGcsfuseLog(req.TargetPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to prepare gcsfuse log: %v", err)
+	}
+	mountOptions = append(mountOptions, fmt.Sprintf("log_file=%s", logFile))
+
+	mountCtx := ctx
+	if driver.mountTimeout > 0 {
+		var cancel context.CancelFunc
+		mountCtx, cancel = context.WithTimeout(ctx, driver.mountTimeout)
+		defer cancel()
+	}
+
+	err = driver.mounter.MountContext(mountCtx, options[flags.FLAG_BUCKET], req.TargetPath, "gcsfuse", mountOptions)
 	if err != nil {
-		if os.IsPermission(err) {
-			return nil, status.Error(codes.PermissionDenied, err.Error())
+		if err := util.RemoveGcsfuseConfig(ConfigStoragePath, req.TargetPath); err != nil {
+			klog.FromContext(ctx).Error(err, "Error removing gcsfuse config")
 		}
-		if strings.Contains(err.Error(), "invalid argument") {
-			return nil, status.Error(codes.InvalidArgument, err.Error())
+		logs := gcsfuseLogTail(ctx, req.TargetPath)
+		if mountCtx.Err() != nil {
+			// gcsfuse may have mounted before it was killed
+			if err := mount.CleanupMountPoint(req.TargetPath, driver.mounter, false); err != nil {
+				klog.FromContext(ctx).Error(err, "Error cleaning up mount point")
+			}
+			// The kubelet retries canceled requests, only a timeout is worth an event
+			if errors.Is(mountCtx.Err(), context.Canceled) {
+				return nil, status.Errorf(codes.Canceled, "Mounting bucket %s was canceled%s", options[flags.FLAG_BUCKET], logs)
+			}
+			err = status.Errorf(codes.DeadlineExceeded, "Mounting bucket %s timed out%s", options[flags.FLAG_BUCKET], logs)
+		} else if os.IsPermission(err) {
+			err = status.Error(codes.PermissionDenied, err.Error()+logs)
+		} else if strings.Contains(err.Error(), "invalid argument") {
+			err = status.Error(codes.InvalidArgument, err.Error()+logs)
+		} else {
+			err = status.Error(codes.Internal, err.Error()+logs)
 		}
-		return nil, status.Error(codes.Internal, err.Error())
+		driver.recordPodEvent(req, EventReasonMountFailed, err)
+		return nil, err
 	}
 
-	if driver.deleteOrphanedPods {
+	// Single writer mounts are registered by their claim
+	if driver.deleteOrphanedPods && !singleWriter {
+		sources := map[string]string{}
+		for name, source := range merger.Sources(options) {
+			sources[name] = string(source)
+		}
+
 		err = util.RegisterMount(
 			ctx,
+			driver.clientset,
+			driver.gcsClientset,
 			req.VolumeId,
 			req.TargetPath,
 			driver.nodeName,
-			req.VolumeContext["csi.storage.k8s.io/pod.namespace"],
-			req.VolumeContext["csi.storage.k8s.io/pod.name"],
+			req.VolumeContext[VolumeContextPodNamespace],
+			req.VolumeContext[VolumeContextPodName],
+			req.VolumeContext[VolumeContextPodUID],
+			readOnly,
 			options,
+			sources,
 		)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	driver.followGcsfuseLog(req, logFile)
+
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
-func (driver *GCSDriver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (response *csi.NodeUnpublishVolumeResponse, err error) {
-	klog.V(4).Infof("Method NodeUnpublishVolume called with: %s", protosanitizer.StripSecrets(req))
+// prepareGcsfuseLog rotates the log file of the mount at the target path before gcsfuse appends to it.
+func (driver *GCSDriver) prepareGcsfuseLog(targetPath string) (string, error) {
+	if err := os.MkdirAll(LogStoragePath, 0700); err != nil {
+		return "", err
+	}
+
+	logFile := util.GcsfuseLogFile(LogStoragePath, targetPath)
+	if _, err := util.RotateLog(logFile, GcsfuseLogMaxSize); err != nil {
+		return "", err
+	}
+
+	return logFile, nil
+}
+
+// gcsfuseLogTail returns the last lines gcsfuse logged for the failed mount at the target path, to be appended
+// to the error, and removes the log file.
+func gcsfuseLogTail(ctx context.Context, targetPath string) string {
+	logger := klog.FromContext(ctx)
 
+	tail, err := util.TailLog(util.GcsfuseLogFile(LogStoragePath, targetPath), GcsfuseLogTailLines)
+	if err != nil {
+		logger.Error(err, "Error reading gcsfuse log")
+	}
+	if err := util.RemoveGcsfuseLog(LogStoragePath, targetPath); err != nil {
+		logger.Error(err, "Error removing gcsfuse log")
+	}
+
+	if tail == "" {
+		return ""
+	}
+	return "\ngcsfuse logs:\n" + tail
+}
+
+// followGcsfuseLog keeps the log file of the mount below its maximum size until the volume is unpublished, and
+// forwards its lines if enabled.
+func (driver *GCSDriver) followGcsfuseLog(req *csi.NodePublishVolumeRequest, logFile string) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	driver.logFollowersMutex.Lock()
+	if previous, found := driver.logFollowers[req.TargetPath]; found {
+		previous()
+	}
+	driver.logFollowers[req.TargetPath] = cancel
+	driver.logFollowersMutex.Unlock()
+
+	logger := klog.Background().WithName("gcsfuse").WithValues(
+		"volumeID", req.VolumeId,
+		"pod", klog.KRef(req.VolumeContext[VolumeContextPodNamespace], req.VolumeContext[VolumeContextPodName]),
+	)
+	go util.FollowLog(ctx, logFile, GcsfuseLogMaxSize, time.Second, func(line string) {
+		if driver.forwardGcsfuseLogs {
+			logger.Info(line)
+		}
+	})
+}
+
+// stopGcsfuseLog stops following the log file of the mount at the target path and removes it.
+func (driver *GCSDriver) stopGcsfuseLog(ctx context.Context, targetPath string) {
+	driver.logFollowersMutex.Lock()
+	if cancel, found := driver.logFollowers[targetPath]; found {
+		cancel()
+		delete(driver.logFollowers, targetPath)
+	}
+	driver.logFollowersMutex.Unlock()
+
+	if err := util.RemoveGcsfuseLog(LogStoragePath, targetPath); err != nil {
+		klog.FromContext(ctx).Error(err, "Error removing gcsfuse log")
+	}
+}
+
+// claimVolumeWriter registers the mount as the single writer of the volume, refusing it if another mount is.
+func (driver *GCSDriver) claimVolumeWriter(ctx context.Context, req *csi.NodePublishVolumeRequest, merger *flags.Merger, options map[string]string) error {
+	sources := map[string]string{}
+	for name, source := range merger.Sources(options) {
+		sources[name] = string(source)
+	}
+
+	err := util.ClaimVolumeWriter(
+		ctx,
+		driver.clientset,
+		driver.gcsClientset,
+		req.VolumeId,
+		req.TargetPath,
+		driver.nodeName,
+		req.VolumeContext[VolumeContextPodNamespace],
+		req.VolumeContext[VolumeContextPodName],
+		req.VolumeContext[VolumeContextPodUID],
+		options,
+		sources,
+	)
+	var claimedErr *util.VolumeClaimedError
+	if errors.As(err, &claimedErr) {
+		return status.Error(codes.FailedPrecondition, claimedErr.Error())
+	} else if err != nil {
+		return status.Errorf(codes.Internal, "Failed to claim volume %s for a single writer: %v", req.VolumeId, err)
+	}
+
+	return nil
+}
+
+// mergePodOwner defaults the uid and gid to the user and group the pod runs as.
+func (driver *GCSDriver) mergePodOwner(ctx context.Context, req *csi.NodePublishVolumeRequest, merger *flags.Merger) error {
+	podNamespace := req.VolumeContext[VolumeContextPodNamespace]
+	podName := req.VolumeContext[VolumeContextPodName]
+	if podNamespace == "" || podName == "" {
+		return status.Errorf(codes.InvalidArgument, "Pod missing in volume context, %s requires podInfoOnMount", flags.FLAG_OWNER_FROM_POD)
+	}
+
+	pod, err := util.GetPod(ctx, driver.clientset, podNamespace, podName)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to get pod %s/%s: %v", podNamespace, podName, err)
+	}
+
+	owner := map[string]string{}
+	uid, gid := util.PodOwner(pod)
+	if uid != nil {
+		owner[flags.FLAG_UID] = strconv.FormatInt(*uid, 10)
+	}
+	if gid != nil {
+		owner[flags.FLAG_GID] = strconv.FormatInt(*gid, 10)
+	}
+
+	if err := merger.Merge(flags.SOURCE_SECURITY_CONTEXT, owner); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return nil
+}
+
+func (driver *GCSDriver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (response *csi.NodeUnpublishVolumeResponse, err error) {
 	// Check arguments
 	if len(req.GetVolumeId()) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
@@ -159,6 +435,28 @@ func (driver *GCSDriver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeU
 		return nil, status.Error(codes.InvalidArgument, "Target path missing in request")
 	}
 
+	if !driver.volumeLocks.TryAcquire(req.VolumeId, req.TargetPath) {
+		return nil, status.Errorf(codes.Aborted, "An operation on volume %s or target path %s is already in progress", req.VolumeId, req.TargetPath)
+	}
+	defer driver.volumeLocks.Release(req.VolumeId, req.TargetPath)
+
+	// Single writer mounts are registered regardless of deleteOrphanedPods, so always remove the record once the
+	// volume is no longer mounted. Volumes without a record are ignored.
+	defer func() {
+		if err != nil {
+			return
+		}
+		if err := util.UnregisterMount(ctx, driver.gcsClientset, req.VolumeId, req.TargetPath, driver.nodeName); err != nil {
+			klog.FromContext(ctx).Error(err, "Error unregistering mount")
+		}
+	}()
+
+	// gcsfuse only reads its config file on startup
+	if err := util.RemoveGcsfuseConfig(ConfigStoragePath, req.TargetPath); err != nil {
+		klog.FromContext(ctx).Error(err, "Error removing gcsfuse config")
+	}
+	driver.stopGcsfuseLog(ctx, req.TargetPath)
+
 	notMnt, err := driver.mounter.IsLikelyNotMountPoint(req.TargetPath)
 
 	if err != nil {
@@ -181,25 +479,34 @@ func (driver *GCSDriver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeU
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	if driver.deleteOrphanedPods {
-		err = util.UnregisterMount(ctx, req.VolumeId, req.TargetPath, driver.nodeName)
-		if err != nil {
-			klog.Error(err)
-		}
-	}
-
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
 func (driver *GCSDriver) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	klog.V(4).Infof("Method NodeGetInfo called with: %s", protosanitizer.StripSecrets(req))
+	region, zone, err := util.GetNodeTopology(ctx, driver.clientset, driver.nodeName)
+	if err != nil {
+		klog.FromContext(ctx).Error(err, "Unable to get the topology of the node", "node", driver.nodeName)
+		return &csi.NodeGetInfoResponse{NodeId: driver.nodeName}, nil
+	}
+
+	segments := map[string]string{}
+	if region != "" {
+		segments[TopologyKeyRegion] = region
+	}
+	if zone != "" {
+		segments[TopologyKeyZone] = zone
+	}
+	if len(segments) == 0 {
+		return &csi.NodeGetInfoResponse{NodeId: driver.nodeName}, nil
+	}
 
-	return &csi.NodeGetInfoResponse{NodeId: driver.nodeName}, nil
+	return &csi.NodeGetInfoResponse{
+		NodeId:             driver.nodeName,
+		AccessibleTopology: &csi.Topology{Segments: segments},
+	}, nil
 }
 
 func (driver *GCSDriver) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	klog.V(4).Infof("Method NodeGetCapabilities called with: %s", protosanitizer.StripSecrets(req))
-
 	return &csi.NodeGetCapabilitiesResponse{Capabilities: []*csi.NodeServiceCapability{
 		{
 			Type: &csi.NodeServiceCapability_Rpc{
@@ -208,30 +515,36 @@ func (driver *GCSDriver) NodeGetCapabilities(ctx context.Context, req *csi.NodeG
 				},
 			},
 		},
+		{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+				},
+			},
+		},
+		{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
+				},
+			},
+		},
 	}}, nil
 }
 
 func (driver *GCSDriver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
-	klog.V(4).Infof("Method NodeStageVolume called with: %s", protosanitizer.StripSecrets(req))
-
 	return nil, status.Errorf(codes.Unimplemented, "NodeStageVolume: not implemented by %s", driver.name)
 }
 
 func (driver *GCSDriver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
-	klog.V(4).Infof("Method NodeUnstageVolume called with: %s", protosanitizer.StripSecrets(req))
-
 	return nil, status.Errorf(codes.Unimplemented, "NodeUnstageVolume: not implemented by %s", driver.name)
 }
 
 func (driver *GCSDriver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
-	klog.V(4).Infof("Method NodeGetVolumeStats called with: %s", protosanitizer.StripSecrets(req))
-
 	return nil, status.Errorf(codes.Unimplemented, "NodeGetVolumeStats: not implemented by %s", driver.name)
 }
 
 func (driver *GCSDriver) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
-	klog.V(4).Infof("Method NodeExpandVolume called with: %s", protosanitizer.StripSecrets(req))
-
 	// Check arguments
 	if len(req.GetVolumeId()) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
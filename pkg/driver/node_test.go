@@ -0,0 +1,558 @@
+package driver_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/mount"
+
+	"github.com/ofek/csi-gcs/pkg/apis/published-volume/v1beta1"
+	gcsfake "github.com/ofek/csi-gcs/pkg/client/clientset/clientset/fake"
+	. "github.com/ofek/csi-gcs/pkg/driver"
+	"github.com/ofek/csi-gcs/pkg/util"
+)
+
+// blockingMounter blocks the first mount point check of a path until released.
+type blockingMounter struct {
+	*mount.FakeMounter
+	path     string
+	once     sync.Once
+	blocked  chan struct{}
+	released chan struct{}
+}
+
+func (m *blockingMounter) IsLikelyNotMountPoint(file string) (bool, error) {
+	if file == m.path {
+		m.once.Do(func() {
+			close(m.blocked)
+			<-m.released
+		})
+	}
+	return m.FakeMounter.IsLikelyNotMountPoint(file)
+}
+
+// hangingMounter mounts like gcsfuse hanging once mounted, until the context is done.
+type hangingMounter struct {
+	*mount.FakeMounter
+}
+
+func (m *hangingMounter) MountContext(ctx context.Context, source string, target string, fstype string, options []string) error {
+	if err := m.Mount(source, target, fstype, options); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+var _ = Describe("Node", func() {
+	var (
+		driver       *GCSDriver
+		clientset    *k8sfake.Clientset
+		gcsClientset *gcsfake.Clientset
+		options      GCSDriverOptions
+	)
+
+	BeforeEach(func() {
+		clientset = k8sfake.NewSimpleClientset(
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}},
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "other-node"}},
+		)
+		gcsClientset = gcsfake.NewSimpleClientset()
+		options = GCSDriverOptions{}
+	})
+
+	JustBeforeEach(func() {
+		driver = newDriver(clientset, gcsClientset, options)
+	})
+
+	Describe("Concurrent operations", func() {
+		var (
+			mounter *blockingMounter
+			dir     string
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "targets")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			mounter = &blockingMounter{
+				FakeMounter: mount.NewFakeMounter(nil),
+				path:        filepath.Join(dir, "blocked"),
+				blocked:     make(chan struct{}),
+				released:    make(chan struct{}),
+			}
+		})
+
+		JustBeforeEach(func() {
+			driver.SetMounter(mounter)
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		unpublish := func(volumeID string, targetPath string) error {
+			_, err := driver.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   volumeID,
+				TargetPath: targetPath,
+			})
+			return err
+		}
+
+		It("should abort operations on a target path in progress", func() {
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(unpublish("v1/_/bucket", mounter.path)).To(Succeed())
+			}()
+			<-mounter.blocked
+
+			var aborted sync.WaitGroup
+			for _, volumeID := range []string{"v1/_/bucket", "v1/_/other"} {
+				aborted.Add(1)
+				go func(volumeID string) {
+					defer GinkgoRecover()
+					defer aborted.Done()
+					Expect(status.Code(unpublish(volumeID, mounter.path))).To(Equal(codes.Aborted))
+				}(volumeID)
+			}
+			aborted.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer aborted.Done()
+				_, err := driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+					VolumeId:         "v1/_/bucket",
+					TargetPath:       filepath.Join(dir, "other"),
+					VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+				})
+				Expect(status.Code(err)).To(Equal(codes.Aborted))
+			}()
+			aborted.Wait()
+
+			close(mounter.released)
+			wg.Wait()
+
+			Expect(unpublish("v1/_/bucket", mounter.path)).To(Succeed())
+		})
+
+		It("should allow operations on other volumes and target paths", func() {
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(unpublish("v1/_/bucket", mounter.path)).To(Succeed())
+			}()
+			<-mounter.blocked
+
+			Expect(unpublish("v1/_/other", filepath.Join(dir, "other"))).To(Succeed())
+
+			close(mounter.released)
+			wg.Wait()
+		})
+	})
+
+	Describe("Events", func() {
+		var recorder *record.FakeRecorder
+
+		JustBeforeEach(func() {
+			recorder = record.NewFakeRecorder(1)
+			driver.SetEventRecorder(recorder)
+		})
+
+		It("should record invalid credentials on the pod", func() {
+			_, err := driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:         "v1/_/bucket",
+				TargetPath:       "/tmp/target",
+				VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+				Secrets:          map[string]string{"key": "not a key"},
+				VolumeContext: map[string]string{
+					VolumeContextPodName:      "app",
+					VolumeContextPodNamespace: "default",
+					VolumeContextPodUID:       "4d3c2b1a",
+				},
+			})
+			Expect(status.Code(err)).To(Equal(codes.Internal))
+
+			Expect(recorder.Events).To(Receive(HavePrefix("Warning CredentialsInvalid Failed to create client: ")))
+		})
+
+		It("should not record events without the pod", func() {
+			_, err := driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:         "v1/_/bucket",
+				TargetPath:       "/tmp/target",
+				VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+				Secrets:          map[string]string{"key": "not a key"},
+			})
+			Expect(err).To(HaveOccurred())
+
+			Expect(recorder.Events).NotTo(Receive())
+		})
+	})
+
+	Describe("Inline volumes", func() {
+		publish := func(secrets map[string]string, volumeContext map[string]string) error {
+			request := &csi.NodePublishVolumeRequest{
+				VolumeId:         "csi-4d3c2b1a",
+				TargetPath:       "/tmp/target",
+				VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+				Secrets:          secrets,
+				VolumeContext: map[string]string{
+					VolumeContextEphemeral:    "true",
+					VolumeContextPodName:      "app",
+					VolumeContextPodNamespace: "default",
+					"bucket":                  "bucket",
+				},
+			}
+			for k, v := range volumeContext {
+				request.VolumeContext[k] = v
+			}
+
+			_, err := driver.NodePublishVolume(context.Background(), request)
+			return err
+		}
+
+		It("should require a secret", func() {
+			err := publish(nil, nil)
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(err.Error()).To(ContainSubstring("nodePublishSecretRef"))
+		})
+
+		Context("with --enforce-bucket-access", func() {
+			BeforeEach(func() {
+				options.EnforceBucketAccess = true
+			})
+
+			It("should leave the bucket to the policies instead of requiring a secret", func() {
+				// Without any policy the bucket is denied as well, but by the policies
+				err := publish(nil, nil)
+				Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+				Expect(err.Error()).NotTo(ContainSubstring("nodePublishSecretRef"))
+			})
+		})
+
+		It("should restrict the secret like volume attributes", func() {
+			for _, secrets := range []map[string]string{
+				{"key": "not a key", "cacheDir": "/etc"},
+				{"key": "not a key", "fuseMountOptions": "key_file=/tmp/keys/other"},
+			} {
+				Expect(status.Code(publish(secrets, nil))).To(Equal(codes.InvalidArgument))
+			}
+		})
+
+		It("should require the bucket volume attribute", func() {
+			err := publish(map[string]string{"key": "not a key"}, map[string]string{"bucket": ""})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			Expect(err.Error()).To(ContainSubstring("Inline volumes require the bucket volume attribute"))
+		})
+	})
+
+	It("should require the bucket of persistent volumes", func() {
+		_, err := driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:         "v1/_/bucket",
+			TargetPath:       "/tmp/target",
+			VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			Secrets:          map[string]string{"key": "not a key"},
+			VolumeContext:    map[string]string{"bucket": ""},
+		})
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		Expect(err.Error()).NotTo(ContainSubstring("Inline volumes"))
+	})
+
+	Describe("Bucket access policies", func() {
+		var (
+			gcs     *fakeStorage
+			mounter *mount.FakeMounter
+			dir     string
+		)
+
+		BeforeEach(func() {
+			options.EnforceBucketAccess = true
+			gcsClientset = gcsfake.NewSimpleClientset(
+				&v1beta1.BucketAccessPolicy{
+					ObjectMeta: metav1.ObjectMeta{Name: "team"},
+					Spec: v1beta1.BucketAccessPolicySpec{
+						Namespaces: []string{"default"},
+						Buckets:    []string{"team-*"},
+						Mode:       v1beta1.BucketAccessReadWrite,
+					},
+				},
+				&v1beta1.BucketAccessPolicy{
+					ObjectMeta: metav1.ObjectMeta{Name: "shared"},
+					Spec: v1beta1.BucketAccessPolicySpec{
+						Namespaces: []string{"default"},
+						Buckets:    []string{"shared-*"},
+						Mode:       v1beta1.BucketAccessReadOnly,
+					},
+				},
+			)
+
+			gcs = startFakeStorage()
+			for _, bucket := range []string{"team-data", "shared-models", "other"} {
+				gcs.AddBucket(bucket, nil)
+			}
+			mounter = mount.NewFakeMounter(nil)
+
+			var err error
+			dir, err = ioutil.TempDir("", "targets")
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		JustBeforeEach(func() {
+			driver.SetMounter(mounter)
+		})
+
+		AfterEach(func() {
+			gcs.Close()
+			os.RemoveAll(dir)
+		})
+
+		publish := func(bucket string, readOnly bool, volumeContext map[string]string) error {
+			_, err := driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:         "v1/_/" + bucket,
+				TargetPath:       filepath.Join(dir, bucket),
+				VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+				Readonly:         readOnly,
+				Secrets:          map[string]string{"key": "{}"},
+				VolumeContext:    volumeContext,
+			})
+			return err
+		}
+		pod := map[string]string{VolumeContextPodName: "app", VolumeContextPodNamespace: "default"}
+
+		It("should mount buckets allowed by a policy of the namespace of the pod", func() {
+			Expect(publish("team-data", false, pod)).To(Succeed())
+			Expect(mounter.MountPoints).To(HaveLen(1))
+			Expect(mounter.MountPoints[0].Device).To(Equal("team-data"))
+		})
+
+		It("should refuse buckets not allowed by any policy", func() {
+			err := publish("other", false, pod)
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(err.Error()).To(ContainSubstring("no bucket access policy allows namespace default to mount bucket other"))
+			Expect(mounter.MountPoints).To(BeEmpty())
+		})
+
+		It("should only mount buckets of read-only policies read-only", func() {
+			Expect(status.Code(publish("shared-models", false, pod))).To(Equal(codes.PermissionDenied))
+			Expect(mounter.MountPoints).To(BeEmpty())
+
+			Expect(publish("shared-models", true, pod)).To(Succeed())
+			Expect(mounter.MountPoints).To(HaveLen(1))
+		})
+
+		It("should require the namespace of the pod", func() {
+			err := publish("team-data", false, nil)
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(err.Error()).To(ContainSubstring("podInfoOnMount"))
+		})
+	})
+
+	Describe("Single writer", func() {
+		publish := func() error {
+			_, err := driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:         "v1/_/bucket",
+				TargetPath:       "/tmp/target",
+				VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER),
+				Secrets:          map[string]string{"key": "not a key"},
+			})
+			return err
+		}
+
+		claim := func(node string, targetPath string) error {
+			return util.ClaimVolumeWriter(context.Background(), clientset, gcsClientset, "v1/_/bucket", targetPath, node, "default", "app", "", nil, nil)
+		}
+
+		It("should refuse a second writer", func() {
+			_, err := clientset.CoreV1().Pods("default").Create(context.Background(), &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+				Spec:       corev1.PodSpec{NodeName: "other-node"},
+			}, metav1.CreateOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(claim("other-node", "/tmp/other")).To(Succeed())
+
+			err = publish()
+			Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+			Expect(err.Error()).To(ContainSubstring("already published at /tmp/other on node other-node"))
+		})
+
+		It("should release the claim if publishing fails", func() {
+			Expect(status.Code(publish())).To(Equal(codes.Internal))
+
+			Expect(claim("other-node", "/tmp/other")).To(Succeed())
+		})
+	})
+
+	Describe("Mounting", func() {
+		var (
+			gcs *fakeStorage
+			dir string
+		)
+
+		BeforeEach(func() {
+			gcs = startFakeStorage()
+			gcs.AddBucket("bucket", nil)
+			options.MountTimeout = 100 * time.Millisecond
+
+			var err error
+			dir, err = ioutil.TempDir("", "targets")
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			gcs.Close()
+			os.RemoveAll(dir)
+		})
+
+		publish := func(targetPath string) error {
+			_, err := driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:         "v1/_/bucket",
+				TargetPath:       targetPath,
+				VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+				Secrets:          map[string]string{"key": "{}"},
+			})
+			return err
+		}
+
+		It("should mount the bucket with gcsfuse", func() {
+			mounter := mount.NewFakeMounter(nil)
+			driver.SetMounter(mounter)
+			targetPath := filepath.Join(dir, "target")
+
+			Expect(publish(targetPath)).To(Succeed())
+			Expect(mounter.MountPoints).To(HaveLen(1))
+			Expect(mounter.MountPoints[0].Device).To(Equal("bucket"))
+			Expect(mounter.MountPoints[0].Type).To(Equal("gcsfuse"))
+
+			_, err := driver.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   "v1/_/bucket",
+				TargetPath: targetPath,
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(mounter.MountPoints).To(BeEmpty())
+		})
+
+		It("should clean up mounts that time out", func() {
+			mounter := &hangingMounter{FakeMounter: mount.NewFakeMounter(nil)}
+			driver.SetMounter(mounter)
+			targetPath := filepath.Join(dir, "target")
+
+			Expect(status.Code(publish(targetPath))).To(Equal(codes.DeadlineExceeded))
+			Expect(mounter.MountPoints).To(BeEmpty())
+			Expect(mounter.GetLog()).To(ContainElement(mount.FakeAction{Action: mount.FakeActionUnmount, Target: targetPath}))
+		})
+
+		It("should kill mount commands that time out", func() {
+			if runtime.GOOS != "linux" {
+				Skip("mounting under a context is only supported on Linux")
+			}
+
+			// Like gcsfuse, the helper outlives the mount command
+			command := filepath.Join(dir, "mount")
+			Expect(ioutil.WriteFile(command, []byte("#!/bin/sh\nsleep 10 & sleep 10\n"), 0755)).To(Succeed())
+			driver.SetMounter(util.NewContextMounter(mount.NewFakeMounter(nil), command))
+			targetPath := filepath.Join(dir, "target")
+
+			start := time.Now()
+			Expect(status.Code(publish(targetPath))).To(Equal(codes.DeadlineExceeded))
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+			Expect(targetPath).NotTo(BeADirectory())
+		})
+	})
+
+	Describe("Owner", func() {
+		var (
+			gcs     *fakeStorage
+			mounter *mount.FakeMounter
+			dir     string
+		)
+
+		BeforeEach(func() {
+			user, group := int64(1000), int64(2000)
+			_, err := clientset.CoreV1().Pods("default").Create(context.Background(), &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+				Spec: corev1.PodSpec{
+					SecurityContext: &corev1.PodSecurityContext{RunAsUser: &user, RunAsGroup: &group},
+					Containers:      []corev1.Container{{Name: "app"}},
+				},
+			}, metav1.CreateOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			gcs = startFakeStorage()
+			gcs.AddBucket("bucket", nil)
+			mounter = mount.NewFakeMounter(nil)
+
+			dir, err = ioutil.TempDir("", "targets")
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		JustBeforeEach(func() {
+			driver.SetMounter(mounter)
+		})
+
+		AfterEach(func() {
+			gcs.Close()
+			os.RemoveAll(dir)
+		})
+
+		// publish mounts the volume for the pod and returns the mount options
+		publish := func(volumeMountGroup string, volumeContext map[string]string) []string {
+			capability := mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+			capability.GetMount().VolumeMountGroup = volumeMountGroup
+			request := &csi.NodePublishVolumeRequest{
+				VolumeId:         "v1/_/bucket",
+				TargetPath:       filepath.Join(dir, "target"),
+				VolumeCapability: capability,
+				Secrets:          map[string]string{"key": "{}"},
+				VolumeContext: map[string]string{
+					VolumeContextPodName:      "app",
+					VolumeContextPodNamespace: "default",
+				},
+			}
+			for k, v := range volumeContext {
+				request.VolumeContext[k] = v
+			}
+
+			_, err := driver.NodePublishVolume(context.Background(), request)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(mounter.MountPoints).To(HaveLen(1))
+			return mounter.MountPoints[0].Opts
+		}
+
+		It("should own mounts by the defaults", func() {
+			options := publish("", nil)
+			Expect(options).To(ContainElement("gid=63147"))
+			Expect(options).NotTo(ContainElement(HavePrefix("uid=")))
+		})
+
+		It("should own mounts by the user and group of the pod with ownerFromPod", func() {
+			Expect(publish("", map[string]string{"ownerFromPod": "true"})).To(ContainElements("uid=1000", "gid=2000"))
+		})
+
+		It("should prefer the fsGroup of the pod", func() {
+			Expect(publish("3000", map[string]string{"ownerFromPod": "true"})).To(ContainElements("uid=1000", "gid=3000"))
+		})
+
+		It("should prefer explicit options", func() {
+			Expect(publish("3000", map[string]string{"ownerFromPod": "true", "uid": "4000", "gid": "5000"})).To(ContainElements("uid=4000", "gid=5000"))
+		})
+	})
+})
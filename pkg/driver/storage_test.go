@@ -0,0 +1,226 @@
+package driver_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// fakeStorage serves the bucket and object calls of the storage client, pointed at it by STORAGE_EMULATOR_HOST.
+// Buckets that weren't added or created are missing, unless their lookup is denied.
+type fakeStorage struct {
+	sync.Mutex
+	server  *httptest.Server
+	buckets map[string]map[string]interface{}
+	objects map[string]map[string]map[string]interface{}
+	denied  map[string]bool
+	creates int
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func startFakeStorage() *fakeStorage {
+	f := &fakeStorage{
+		buckets: map[string]map[string]interface{}{},
+		objects: map[string]map[string]map[string]interface{}{},
+		denied:  map[string]bool{},
+	}
+	f.server = httptest.NewServer(f)
+	os.Setenv("STORAGE_EMULATOR_HOST", f.server.URL)
+
+	return f
+}
+
+func (f *fakeStorage) Close() {
+	os.Unsetenv("STORAGE_EMULATOR_HOST")
+	f.server.Close()
+}
+
+// AddBucket adds a bucket with the labels, e.g. one provisioned before.
+func (f *fakeStorage) AddBucket(name string, labels map[string]string) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.buckets[name] = map[string]interface{}{"kind": "storage#bucket", "name": name, "location": "US", "labels": labels}
+	f.objects[name] = map[string]map[string]interface{}{}
+}
+
+// AddObject adds an object with the metadata to an existing bucket.
+func (f *fakeStorage) AddObject(bucket string, name string, metadata map[string]string) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.objects[bucket][name] = map[string]interface{}{"kind": "storage#object", "bucket": bucket, "name": name, "metadata": metadata}
+}
+
+// Bucket returns the attributes of the bucket, nil if it doesn't exist.
+func (f *fakeStorage) Bucket(name string) map[string]interface{} {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.buckets[name]
+}
+
+// Object returns the attributes of the object, nil if it doesn't exist.
+func (f *fakeStorage) Object(bucket string, name string) map[string]interface{} {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.objects[bucket][name]
+}
+
+// Label returns the value of a label of the bucket.
+func (f *fakeStorage) Label(bucket string, key string) string {
+	f.Lock()
+	defer f.Unlock()
+
+	labels, _ := f.buckets[bucket]["labels"].(map[string]string)
+	return labels[key]
+}
+
+// Block blocks the next request until released. The returned channel is closed once the request is blocked.
+func (f *fakeStorage) Block() (blocked <-chan struct{}, release func()) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.blocked, f.release = make(chan struct{}), make(chan struct{})
+	released := f.release
+	return f.blocked, func() { close(released) }
+}
+
+func (f *fakeStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	blocked, release := f.blocked, f.release
+	f.blocked, f.release = nil, nil
+	f.Unlock()
+	if blocked != nil {
+		close(blocked)
+		<-release
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	path := strings.TrimPrefix(r.URL.Path, "/storage/v1/b")
+	if path == "" {
+		if r.Method == http.MethodPost {
+			f.createBucket(w, r)
+			return
+		}
+
+		var items []interface{}
+		for _, name := range f.bucketNames() {
+			items = append(items, f.buckets[name])
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"kind": "storage#buckets", "items": items})
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	name := parts[0]
+	if f.denied[name] {
+		writeError(w, http.StatusForbidden)
+		return
+	}
+	bucket := f.buckets[name]
+	if bucket == nil {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+
+	if len(parts) > 1 {
+		f.serveObjects(w, r, name, parts[1:])
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(bucket)
+	case http.MethodPatch:
+		var update struct{ Labels map[string]string }
+		json.NewDecoder(r.Body).Decode(&update)
+		labels, _ := bucket["labels"].(map[string]string)
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		for k, v := range update.Labels {
+			labels[k] = v
+		}
+		bucket["labels"] = labels
+		json.NewEncoder(w).Encode(bucket)
+	case http.MethodDelete:
+		delete(f.buckets, name)
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeStorage) createBucket(w http.ResponseWriter, r *http.Request) {
+	var attrs struct {
+		Name     string
+		Location string
+		Labels   map[string]string
+	}
+	json.NewDecoder(r.Body).Decode(&attrs)
+
+	if f.buckets[attrs.Name] != nil {
+		writeError(w, http.StatusConflict)
+		return
+	}
+
+	f.creates++
+	f.buckets[attrs.Name] = map[string]interface{}{"kind": "storage#bucket", "name": attrs.Name, "location": strings.ToUpper(attrs.Location), "labels": attrs.Labels}
+	f.objects[attrs.Name] = map[string]map[string]interface{}{}
+	json.NewEncoder(w).Encode(f.buckets[attrs.Name])
+}
+
+func (f *fakeStorage) serveObjects(w http.ResponseWriter, r *http.Request, bucket string, parts []string) {
+	objects := f.objects[bucket]
+
+	if len(parts) == 1 {
+		var items []interface{}
+		for name, object := range objects {
+			if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+				items = append(items, object)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"kind": "storage#objects", "items": items})
+		return
+	}
+
+	object := objects[parts[1]]
+	if object == nil {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(object)
+	case http.MethodDelete:
+		delete(objects, parts[1])
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeStorage) bucketNames() []string {
+	var names []string
+	for name := range f.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func writeError(w http.ResponseWriter, code int) {
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `{"error": {"code": %d, "message": "%s"}}`, code, http.StatusText(code))
+}
@@ -0,0 +1,57 @@
+package flags
+
+import (
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// GcsfuseConfig renders the options that have a gcsfuse config file key as a config file.
+func GcsfuseConfig(flags map[string]string) ([]byte, error) {
+	config := map[string]interface{}{}
+
+	for _, option := range Registry {
+		value, found := flags[option.Name]
+		if !found || option.ConfigKey == "" {
+			continue
+		}
+
+		keys := strings.Split(option.ConfigKey, ".")
+		section := config
+		for _, key := range keys[:len(keys)-1] {
+			child, found := section[key].(map[string]interface{})
+			if !found {
+				child = map[string]interface{}{}
+				section[key] = child
+			}
+			section = child
+		}
+		section[keys[len(keys)-1]] = configValue(&option, value)
+	}
+
+	return yaml.Marshal(config)
+}
+
+// ConfigFileFlags renders the options that have no gcsfuse config file key as mount options.
+func ConfigFileFlags(flags map[string]string) []string {
+	return renderFlags(flags, func(option *Option) bool {
+		return option.ConfigKey == ""
+	})
+}
+
+func configValue(option *Option, value string) interface{} {
+	switch option.Type {
+	case TYPE_FLAG:
+		parsed, _ := strconv.ParseBool(value)
+		return parsed
+	case TYPE_ID, TYPE_INTEGER:
+		parsed, _ := strconv.ParseInt(value, 10, 64)
+		return parsed
+	case TYPE_NUMBER:
+		parsed, _ := strconv.ParseFloat(value, 64)
+		return parsed
+	}
+
+	return value
+}
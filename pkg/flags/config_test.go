@@ -0,0 +1,48 @@
+package flags_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/ofek/csi-gcs/pkg/flags"
+)
+
+var _ = Describe("Config", func() {
+	Describe("GcsfuseConfig", func() {
+		It("Should render typed sections", func() {
+			config, err := GcsfuseConfig(map[string]string{
+				"bucket":               "test",
+				"dirMode":              "0775",
+				"gid":                  "63147",
+				"implicitDirs":         "true",
+				"limitOpsPerSec":       "-1",
+				"sequentialReadSizeMB": "200",
+				"clientProtocol":       "grpc",
+				"statCacheTTL":         "1h",
+				"fuseMountOptions":     "foo",
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(config)).To(Equal(`file-system:
+  dir-mode: "0775"
+  gid: 63147
+gcs-connection:
+  client-protocol: grpc
+  limit-ops-per-sec: -1
+  sequential-read-size-mb: 200
+implicit-dirs: true
+`))
+		})
+	})
+
+	Describe("ConfigFileFlags", func() {
+		It("Should only render options without a config key", func() {
+			Expect(
+				ConfigFileFlags(map[string]string{
+					"fuseMountOptions": "foo,bar",
+					"dirMode":          "0600",
+					"statCacheTTL":     "1h",
+				}),
+			).To(Equal([]string{"foo", "bar", "stat_cache_ttl=1h"}))
+		})
+	})
+})
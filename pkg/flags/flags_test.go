@@ -93,5 +93,35 @@ var _ = Describe("Flags", func() {
 				),
 			).To(Equal([]string{"foo", "bar", "baz", "dir_mode=0600", "implicit_dirs"}))
 		})
+		It("Should render only-dir", func() {
+			Expect(
+				ExtraFlags(
+					map[string]string{
+						"bucket":       "test",
+						"parentBucket": "test",
+						"onlyDir":      "pvc-1",
+					},
+				),
+			).To(Equal([]string{"only_dir=pvc-1"}))
+		})
+		It("Should render tuning options after fuse mount options", func() {
+			Expect(
+				ExtraFlags(
+					map[string]string{
+						"fuseMountOptions":     "client_protocol=http1",
+						"clientProtocol":       "grpc",
+						"sequentialReadSizeMB": "200",
+						"enableHNS":            "true",
+						"cacheDir":             "/var/cache/gcsfuse",
+					},
+				),
+			).To(Equal([]string{
+				"client_protocol=http1",
+				"cache_dir=/var/cache/gcsfuse",
+				"sequential_read_size_mb=200",
+				"client_protocol=grpc",
+				"enable_hns",
+			}))
+		})
 	})
 })
@@ -0,0 +1,229 @@
+package flags
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ofek/csi-gcs/pkg/util"
+)
+
+// Source identifies where the value of an option comes from.
+type Source string
+
+const (
+	SOURCE_DEFAULT          Source = "default"
+	SOURCE_SECRET           Source = "secret"
+	SOURCE_MOUNT_FLAG       Source = "mount flag"
+	SOURCE_ANNOTATION       Source = "annotation"
+	SOURCE_PARAMETER        Source = "StorageClass parameter"
+	SOURCE_VOLUME_CONTEXT   Source = "volume context"
+	SOURCE_INLINE_VOLUME    Source = "inline volume attribute"
+	SOURCE_FS_GROUP         Source = "fsGroup"
+	SOURCE_SECURITY_CONTEXT Source = "pod security context"
+)
+
+// OptionError describes an option value that failed validation.
+type OptionError struct {
+	Source Source
+	Name   string
+	Value  string
+	Reason string
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("invalid %s %s=%q: %s", e.Source, e.Name, e.Value, e.Reason)
+}
+
+var (
+	// https://cloud.google.com/storage/docs/locations
+	locationPattern = regexp.MustCompile(`^[a-zA-Z]+[0-9]*(-[a-zA-Z]+[0-9]+)?$`)
+	kmsKeyPattern   = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+)
+
+// Options is the typed form of the merged options.
+type Options struct {
+	Bucket             string
+	ProjectID          string
+	KMSKeyID           string
+	Location           string
+	FuseMountOptions   []string
+	DirMode            *uint32
+	FileMode           *uint32
+	UID                *int64
+	GID                *int64
+	ImplicitDirs       bool
+	BillingProject     string
+	LimitBytesPerSec   *float64
+	LimitOpsPerSec     *float64
+	StatCacheTTL       *time.Duration
+	TypeCacheTTL       *time.Duration
+	MaxRetrySleep      *time.Duration
+	ParentBucket       string
+	OnlyDir            string
+	BucketNameTemplate string
+	CapacityBudget     *int64
+
+	CacheDir               string
+	FileCacheMaxSizeMB     *int64
+	TempDir                string
+	SequentialReadSizeMB   *int64
+	KernelListCacheTTLSecs *int64
+	MaxConnsPerHost        *int64
+	ClientProtocol         string
+	RenameDirLimit         *int64
+	EnableHNS              bool
+	LogSeverity            string
+	LogFormat              string
+	OwnerFromPod           bool
+}
+
+// ParseOptions validates the merged options and converts them to their typed form.
+func ParseOptions(flags map[string]string) (*Options, error) {
+	if err := ValidateFlags(SOURCE_DEFAULT, flags); err != nil {
+		return nil, err
+	}
+
+	options := &Options{
+		Bucket:             flags[FLAG_BUCKET],
+		ProjectID:          flags[FLAG_PROJECT_ID],
+		KMSKeyID:           flags[FLAG_KMS_KEY_ID],
+		Location:           flags[FLAG_LOCATION],
+		BillingProject:     flags[FLAG_BILLING_PROJECT],
+		ParentBucket:       flags[FLAG_PARENT_BUCKET],
+		OnlyDir:            flags[FLAG_ONLY_DIR],
+		BucketNameTemplate: flags[FLAG_BUCKET_NAME_TEMPLATE],
+		CacheDir:           flags[FLAG_CACHE_DIR],
+		TempDir:            flags[FLAG_TEMP_DIR],
+		ClientProtocol:     flags[FLAG_CLIENT_PROTOCOL],
+		LogSeverity:        flags[FLAG_LOG_SEVERITY],
+		LogFormat:          flags[FLAG_LOG_FORMAT],
+	}
+
+	if value, found := flags[FLAG_FUSE_MOUNT_OPTION]; found && value != "" {
+		options.FuseMountOptions = strings.Split(value, ",")
+	}
+	if value, found := flags[FLAG_DIR_MODE]; found {
+		mode, _ := parseMode(value)
+		options.DirMode = &mode
+	}
+	if value, found := flags[FLAG_FILE_MODE]; found {
+		mode, _ := parseMode(value)
+		options.FileMode = &mode
+	}
+	if value, found := flags[FLAG_UID]; found {
+		id, _ := strconv.ParseInt(value, 10, 64)
+		options.UID = &id
+	}
+	if value, found := flags[FLAG_GID]; found {
+		id, _ := strconv.ParseInt(value, 10, 64)
+		options.GID = &id
+	}
+	if value, found := flags[FLAG_IMPLICIT_DIRS]; found {
+		options.ImplicitDirs, _ = strconv.ParseBool(value)
+	}
+	if value, found := flags[FLAG_LIMIT_BYTES_PER_SEC]; found {
+		limit, _ := strconv.ParseFloat(value, 64)
+		options.LimitBytesPerSec = &limit
+	}
+	if value, found := flags[FLAG_LIMIT_OPS_PER_SEC]; found {
+		limit, _ := strconv.ParseFloat(value, 64)
+		options.LimitOpsPerSec = &limit
+	}
+	if value, found := flags[FLAG_STAT_CACHE_TTL]; found {
+		ttl, _ := time.ParseDuration(value)
+		options.StatCacheTTL = &ttl
+	}
+	if value, found := flags[FLAG_TYPE_CACHE_TTL]; found {
+		ttl, _ := time.ParseDuration(value)
+		options.TypeCacheTTL = &ttl
+	}
+	if value, found := flags[FLAG_MAX_RETRY_SLEEP]; found {
+		sleep, _ := optionsByName[FLAG_MAX_RETRY_SLEEP].parseDuration(value)
+		options.MaxRetrySleep = &sleep
+	}
+	if value, found := flags[FLAG_CAPACITY_BUDGET]; found {
+		budget, _ := util.ParseCapacity(value)
+		options.CapacityBudget = &budget
+	}
+	if value, found := flags[FLAG_ENABLE_HNS]; found {
+		options.EnableHNS, _ = strconv.ParseBool(value)
+	}
+	if value, found := flags[FLAG_OWNER_FROM_POD]; found {
+		options.OwnerFromPod, _ = strconv.ParseBool(value)
+	}
+
+	for name, target := range map[string]**int64{
+		FLAG_FILE_CACHE_MAX_SIZE_MB:     &options.FileCacheMaxSizeMB,
+		FLAG_SEQUENTIAL_READ_SIZE_MB:    &options.SequentialReadSizeMB,
+		FLAG_KERNEL_LIST_CACHE_TTL_SECS: &options.KernelListCacheTTLSecs,
+		FLAG_MAX_CONNS_PER_HOST:         &options.MaxConnsPerHost,
+		FLAG_RENAME_DIR_LIMIT:           &options.RenameDirLimit,
+	} {
+		if value, found := flags[name]; found {
+			number, _ := strconv.ParseInt(value, 10, 64)
+			*target = &number
+		}
+	}
+
+	return options, nil
+}
+
+// ValidateFlags validates options keyed by flag name, ignoring unknown keys.
+func ValidateFlags(source Source, flags map[string]string) error {
+	for name, value := range flags {
+		option, found := LookupOption(name)
+		if !found {
+			continue
+		}
+		if err := validateOption(source, option, value); err != nil {
+			return &OptionError{Source: source, Name: name, Value: value, Reason: err.Error()}
+		}
+	}
+
+	return nil
+}
+
+// ValidateAnnotations validates options keyed by annotation, ignoring annotations of other prefixes.
+func ValidateAnnotations(source Source, annotations map[string]string) error {
+	for annotation, value := range annotations {
+		option, found := optionsByAnnotation[annotation]
+		if !found {
+			continue
+		}
+		if err := validateOption(source, option, value); err != nil {
+			return &OptionError{Source: source, Name: annotation, Value: value, Reason: err.Error()}
+		}
+	}
+
+	return nil
+}
+
+// ValidateFlag validates the value of a single option.
+func ValidateFlag(name string, value string) error {
+	option, found := LookupOption(name)
+	if !found {
+		return nil
+	}
+
+	return option.Validate(value)
+}
+
+func validateOption(source Source, option *Option, value string) error {
+	if !option.AllowsSource(source) {
+		return fmt.Errorf("cannot be set by %s", source)
+	}
+
+	return option.Validate(value)
+}
+
+func parseMode(value string) (uint32, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("expected octal permission bits such as 0775")
+	}
+
+	return uint32(mode), nil
+}
@@ -0,0 +1,131 @@
+package flags_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/ofek/csi-gcs/pkg/flags"
+)
+
+var _ = Describe("Options", func() {
+	Describe("ValidateFlag", func() {
+		It("Should accept valid values", func() {
+			for name, values := range map[string][]string{
+				FLAG_BUCKET:                  {"my-bucket", "example.com"},
+				FLAG_LOCATION:                {"US", "eu", "us-central1", "nam4", "asia-northeast1"},
+				FLAG_KMS_KEY_ID:              {"", "projects/p/locations/us-east1/keyRings/r/cryptoKeys/k"},
+				FLAG_DIR_MODE:                {"0775", "755", "0"},
+				FLAG_UID:                     {"-1", "0", "4294967294"},
+				FLAG_IMPLICIT_DIRS:           {"true", "false"},
+				FLAG_LIMIT_BYTES_PER_SEC:     {"-1", "0", "1048576", "0.5"},
+				FLAG_STAT_CACHE_TTL:          {"0", "30s", "1h"},
+				FLAG_MAX_RETRY_SLEEP:         {"1m", "1", "0"},
+				FLAG_CAPACITY_BUDGET:         {"10Ti"},
+				FLAG_CACHE_DIR:               {"/tmp/cache"},
+				FLAG_FILE_CACHE_MAX_SIZE_MB:  {"-1", "0", "1024"},
+				FLAG_SEQUENTIAL_READ_SIZE_MB: {"1", "200", "1024"},
+				FLAG_CLIENT_PROTOCOL:         {"grpc"},
+				FLAG_LOG_SEVERITY:            {"trace", "off"},
+			} {
+				for _, value := range values {
+					Expect(ValidateFlag(name, value)).To(Succeed(), name+"="+value)
+				}
+			}
+		})
+
+		It("Should reject invalid values", func() {
+			for name, values := range map[string][]string{
+				FLAG_BUCKET:                  {"My_Bucket", "goog-bucket"},
+				FLAG_LOCATION:                {"us central", "us-central1-a-b"},
+				FLAG_KMS_KEY_ID:              {"my-key"},
+				FLAG_DIR_MODE:                {"0779", "rwx", "01777"},
+				FLAG_GID:                     {"-2", "4294967295", "root"},
+				FLAG_IMPLICIT_DIRS:           {"yes please"},
+				FLAG_LIMIT_OPS_PER_SEC:       {"-5", "fast"},
+				FLAG_TYPE_CACHE_TTL:          {"1", "-1s", "forever"},
+				FLAG_CAPACITY_BUDGET:         {"lots"},
+				FLAG_LIMIT_BYTES_PER_SEC:     {""},
+				FLAG_TEMP_DIR:                {"tmp", "/tmp/../etc", "/tmp/"},
+				FLAG_FILE_CACHE_MAX_SIZE_MB:  {"-2", "1.5"},
+				FLAG_SEQUENTIAL_READ_SIZE_MB: {"0", "1025"},
+				FLAG_MAX_CONNS_PER_HOST:      {"-1"},
+				FLAG_CLIENT_PROTOCOL:         {"http3", "GRPC"},
+				FLAG_LOG_FORMAT:              {"yaml"},
+			} {
+				for _, value := range values {
+					Expect(ValidateFlag(name, value)).NotTo(Succeed(), name+"="+value)
+				}
+			}
+		})
+	})
+
+	Describe("ValidateAnnotations", func() {
+		It("Should report the source and annotation", func() {
+			err := ValidateAnnotations(SOURCE_ANNOTATION, map[string]string{
+				"gcs.csi.ofek.dev/dir-mode": "999",
+				"example.com/dir-mode":      "999",
+			})
+			Expect(err).To(MatchError(`invalid annotation gcs.csi.ofek.dev/dir-mode="999": expected octal permission bits such as 0775`))
+		})
+	})
+
+	Describe("MergeMountOptions", func() {
+		It("Should fail on unknown flags", func() {
+			_, err := MergeMountOptions(map[string]string{}, []string{"--foo=bar"})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should fail on invalid values", func() {
+			_, err := MergeMountOptions(map[string]string{}, []string{"--stat-cache-ttl=1", "--bucket=test2"})
+			Expect(err).To(BeAssignableToTypeOf(&OptionError{}))
+			Expect(err.(*OptionError).Source).To(Equal(SOURCE_MOUNT_FLAG))
+		})
+	})
+
+	Describe("ParseOptions", func() {
+		It("Should convert to typed values", func() {
+			options, err := ParseOptions(map[string]string{
+				"bucket":           "test",
+				"dirMode":          "0750",
+				"gid":              "63147",
+				"implicitDirs":     "true",
+				"statCacheTTL":     "1m",
+				"fuseMountOptions": "foo,bar",
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(options.Bucket).To(Equal("test"))
+			Expect(*options.DirMode).To(Equal(uint32(0750)))
+			Expect(*options.GID).To(Equal(int64(63147)))
+			Expect(options.UID).To(BeNil())
+			Expect(options.ImplicitDirs).To(BeTrue())
+			Expect(*options.StatCacheTTL).To(Equal(time.Minute))
+			Expect(options.FuseMountOptions).To(Equal([]string{"foo", "bar"}))
+		})
+
+		It("Should convert gcsfuse tuning options", func() {
+			options, err := ParseOptions(map[string]string{
+				"sequentialReadSizeMB": "200",
+				"clientProtocol":       "grpc",
+				"enableHNS":            "true",
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(*options.SequentialReadSizeMB).To(Equal(int64(200)))
+			Expect(options.ClientProtocol).To(Equal("grpc"))
+			Expect(options.EnableHNS).To(BeTrue())
+			Expect(options.FileCacheMaxSizeMB).To(BeNil())
+		})
+
+		It("Should take integer retry sleeps as minutes like older releases", func() {
+			options, err := ParseOptions(map[string]string{"maxRetrySleep": "2"})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(*options.MaxRetrySleep).To(Equal(2 * time.Minute))
+
+			merger := NewMerger(context.Background(), map[string]string{})
+			Expect(merger.MergeAnnotations(SOURCE_ANNOTATION, map[string]string{"gcs.csi.ofek.dev/max-retry-sleep": "1"})).To(Succeed())
+			Expect(merger.Options()).To(Equal(map[string]string{"maxRetrySleep": "1m0s"}))
+		})
+	})
+})
@@ -0,0 +1,338 @@
+package flags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// SOURCE_COMPUTED marks values the driver derived itself, such as generated bucket names.
+const SOURCE_COMPUTED Source = "computed"
+
+// VOLUME_CONTEXT_OPTION_SOURCES is the volume context key recording the source of every option.
+const VOLUME_CONTEXT_OPTION_SOURCES = ANNOTATION_PREFIX + "option-sources"
+
+// Precedence ranks the sources, values of a higher rank override values of a lower rank regardless of the
+// order in which sources are merged. The volume context holds the options the controller merged from
+// annotations and StorageClass parameters, so it ranks with the latter. Attributes of inline volumes are set by
+// the pod author, so they rank with annotations. The security context and fsGroup of the pod only replace the
+// default uid and gid, fsGroup being meant for volumes it wins over the group of the pod's processes.
+var Precedence = map[Source]int{
+	SOURCE_DEFAULT:          0,
+	SOURCE_SECURITY_CONTEXT: 1,
+	SOURCE_FS_GROUP:         2,
+	SOURCE_SECRET:           3,
+	SOURCE_MOUNT_FLAG:       4,
+	SOURCE_ANNOTATION:       5,
+	SOURCE_INLINE_VOLUME:    5,
+	SOURCE_PARAMETER:        6,
+	SOURCE_VOLUME_CONTEXT:   6,
+}
+
+// LockedOptionError describes an annotation setting an option locked by the administrator.
+type LockedOptionError struct {
+	Name       string
+	Annotation string
+}
+
+func (e *LockedOptionError) Error() string {
+	return fmt.Sprintf("%s %s is locked by the administrator, %s may not be set", SOURCE_ANNOTATION, e.Annotation, e.Name)
+}
+
+// Merger merges options by precedence and records the source of every value.
+type Merger struct {
+	options map[string]string
+	sources map[string]Source
+	locked  map[string]bool
+	policy  AnnotationPolicy
+	logger  klog.Logger
+}
+
+// NewMerger returns a merger starting from the default options, logging unknown options to the logger of the context.
+func NewMerger(ctx context.Context, defaults map[string]string) *Merger {
+	m := &Merger{
+		options: map[string]string{},
+		sources: map[string]Source{},
+		locked:  map[string]bool{},
+		logger:  klog.FromContext(ctx),
+	}
+	for k, v := range defaults {
+		m.set(SOURCE_DEFAULT, k, v)
+	}
+
+	return m
+}
+
+// Lock prevents annotations from setting the options.
+func (m *Merger) Lock(names ...string) error {
+	for _, name := range names {
+		if !IsFlag(name) {
+			return fmt.Errorf("cannot lock unknown option %q", name)
+		}
+		m.locked[name] = true
+	}
+
+	return nil
+}
+
+// Restrict checks PersistentVolumeClaim annotations against the policy, a nil policy allows every annotation.
+func (m *Merger) Restrict(policy AnnotationPolicy) {
+	m.policy = policy
+}
+
+// Merge validates and merges options keyed by flag name.
+func (m *Merger) Merge(source Source, flags map[string]string) error {
+	if err := ValidateFlags(source, flags); err != nil {
+		return err
+	}
+
+	for k, v := range flags {
+		if !IsFlag(k) {
+			// Secrets hold the credentials along with options, and keys qualified by a prefix belong to other
+			// components, e.g. pod info
+			if source != SOURCE_SECRET && !strings.Contains(k, "/") {
+				m.logger.Info("Ignoring unknown option", "source", source, "option", k)
+			}
+			continue
+		}
+		m.set(source, k, v)
+	}
+
+	return nil
+}
+
+// MergeVolumeContext merges the volume context, keeping the sources recorded by the controller.
+func (m *Merger) MergeVolumeContext(volumeContext map[string]string) error {
+	if err := m.Merge(SOURCE_VOLUME_CONTEXT, volumeContext); err != nil {
+		return err
+	}
+
+	for k, source := range ParseSources(volumeContext[VOLUME_CONTEXT_OPTION_SOURCES]) {
+		if value, found := volumeContext[k]; found && m.options[k] == value && m.sources[k] == SOURCE_VOLUME_CONTEXT {
+			m.sources[k] = source
+		}
+	}
+
+	return nil
+}
+
+// MergeAnnotations validates and merges options keyed by annotation, ignoring annotations of other prefixes.
+func (m *Merger) MergeAnnotations(source Source, annotations map[string]string) error {
+	if source == SOURCE_ANNOTATION && m.policy != nil {
+		if err := m.policy.Check(annotations); err != nil {
+			return err
+		}
+	}
+	if err := ValidateAnnotations(source, annotations); err != nil {
+		return err
+	}
+
+	for k, v := range annotations {
+		if !IsOwnAnnotation(k) || k == VOLUME_CONTEXT_OPTION_SOURCES {
+			continue
+		}
+		name := FlagNameFromAnnotation(k)
+		if name == "" {
+			m.logger.Info("Ignoring unknown option", "source", source, "annotation", k)
+			continue
+		}
+		if source == SOURCE_ANNOTATION && m.locked[name] {
+			return &LockedOptionError{Name: name, Annotation: k}
+		}
+		m.set(source, name, v)
+	}
+
+	return nil
+}
+
+// MergeVolumeMountGroup merges the group the CO asks the volume to be owned by, usually the fsGroup of the pod.
+func (m *Merger) MergeVolumeMountGroup(group string) error {
+	if group == "" {
+		return nil
+	}
+
+	return m.Merge(SOURCE_FS_GROUP, map[string]string{FLAG_GID: group})
+}
+
+// MergeMountOptions validates and merges mount flags.
+func (m *Merger) MergeMountOptions(mountFlags []string) error {
+	parsed, err := ParseMountOptions(mountFlags)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range parsed {
+		m.set(SOURCE_MOUNT_FLAG, k, v)
+	}
+
+	return nil
+}
+
+// Options returns the merged options.
+func (m *Merger) Options() map[string]string {
+	result := make(map[string]string, len(m.options))
+	for k, v := range m.options {
+		result[k] = v
+	}
+
+	return result
+}
+
+// Sources returns the source of every option, values that were not merged are attributed to SOURCE_COMPUTED.
+func (m *Merger) Sources(options map[string]string) map[string]Source {
+	result := make(map[string]Source, len(options))
+	for k, v := range options {
+		if !IsFlag(k) {
+			continue
+		}
+		if source, found := m.sources[k]; found && m.options[k] == v {
+			result[k] = source
+		} else {
+			result[k] = SOURCE_COMPUTED
+		}
+	}
+
+	return result
+}
+
+func (m *Merger) set(source Source, name string, value string) {
+	if current, found := m.sources[name]; found && Precedence[current] > Precedence[source] {
+		return
+	}
+	if option, found := optionsByName[name]; found {
+		value = option.normalize(value)
+	}
+	m.options[name] = value
+	m.sources[name] = source
+}
+
+// FormatSources renders the sources of options as a sorted list of name=source pairs.
+func FormatSources(sources map[string]Source) string {
+	pairs := make([]string, 0, len(sources))
+	for name, source := range sources {
+		pairs = append(pairs, name+"="+string(source))
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+// ParseSources parses the sources rendered by FormatSources.
+func ParseSources(value string) map[string]Source {
+	sources := map[string]Source{}
+	for _, pair := range strings.Split(value, ",") {
+		if parts := strings.SplitN(pair, "=", 2); len(parts) == 2 && IsFlag(parts[0]) {
+			sources[parts[0]] = Source(parts[1])
+		}
+	}
+
+	return sources
+}
+
+// ParseLockedOptions parses a comma-separated list of option names.
+func ParseLockedOptions(value string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !IsFlag(name) {
+			return nil, fmt.Errorf("cannot lock unknown option %q", name)
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+func validateLockedOptions(value string) error {
+	_, err := ParseLockedOptions(value)
+	return err
+}
+
+// AnnotationPolicy restricts the options PersistentVolumeClaim annotations may set, and optionally their values.
+type AnnotationPolicy map[string]*regexp.Regexp
+
+// ParseAnnotationPolicy parses a comma-separated list of option names, each optionally followed by
+// =<pattern> that the whole value must match.
+func ParseAnnotationPolicy(value string) (AnnotationPolicy, error) {
+	policy := AnnotationPolicy{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if !IsFlag(parts[0]) {
+			return nil, fmt.Errorf("cannot allow unknown option %q", parts[0])
+		}
+
+		policy[parts[0]] = nil
+		if len(parts) == 2 {
+			pattern, err := regexp.Compile("^(?:" + parts[1] + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern of option %s: %v", parts[0], err)
+			}
+			policy[parts[0]] = pattern
+		}
+	}
+
+	return policy, nil
+}
+
+func validateAnnotationPolicy(value string) error {
+	_, err := ParseAnnotationPolicy(value)
+	return err
+}
+
+// DeniedAnnotationError describes an annotation that is not allowed by the annotation policy.
+type DeniedAnnotationError struct {
+	Annotation string
+	Value      string
+	Reason     string
+}
+
+func (e *DeniedAnnotationError) Error() string {
+	return fmt.Sprintf("%s %s=%q is not allowed: %s", SOURCE_ANNOTATION, e.Annotation, e.Value, e.Reason)
+}
+
+// Check returns an error for the first annotation the policy does not allow.
+func (p AnnotationPolicy) Check(annotations map[string]string) error {
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		name := FlagNameFromAnnotation(k)
+		if name == "" {
+			continue
+		}
+
+		pattern, allowed := p[name]
+		if !allowed {
+			return &DeniedAnnotationError{Annotation: k, Value: annotations[k], Reason: "option is not in the allowed annotations"}
+		}
+		if pattern != nil && !pattern.MatchString(annotations[k]) {
+			return &DeniedAnnotationError{Annotation: k, Value: annotations[k], Reason: fmt.Sprintf("value does not match %s", pattern)}
+		}
+	}
+
+	return nil
+}
+
+// IsDenied reports whether the error was caused by a locked option or the annotation policy.
+func IsDenied(err error) bool {
+	var lockedErr *LockedOptionError
+	var deniedErr *DeniedAnnotationError
+
+	return errors.As(err, &lockedErr) || errors.As(err, &deniedErr)
+}
@@ -0,0 +1,212 @@
+package flags_test
+
+import (
+	"context"
+
+	"github.com/go-logr/logr/funcr"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/klog/v2"
+
+	. "github.com/ofek/csi-gcs/pkg/flags"
+)
+
+var _ = Describe("Precedence", func() {
+	Describe("Merger", func() {
+		It("Should only log unknown options that aren't credentials", func() {
+			var lines []string
+			ctx := klog.NewContext(context.Background(), funcr.New(func(prefix, args string) {
+				lines = append(lines, args)
+			}, funcr.Options{}))
+
+			merger := NewMerger(ctx, nil)
+			Expect(merger.Merge(SOURCE_SECRET, map[string]string{"key": "{}", "key.json": "{}", "uid": "0"})).To(Succeed())
+			Expect(lines).To(BeEmpty())
+
+			Expect(merger.Merge(SOURCE_INLINE_VOLUME, map[string]string{"unknown": "value", "csi.storage.k8s.io/pod.name": "app"})).To(Succeed())
+			Expect(lines).To(ConsistOf(ContainSubstring(`"option"="unknown"`)))
+		})
+
+		It("Should merge by precedence regardless of order", func() {
+			merger := NewMerger(context.Background(), map[string]string{"gid": "63147", "dirMode": "0775"})
+			Expect(merger.MergeAnnotations(SOURCE_PARAMETER, map[string]string{
+				"gcs.csi.ofek.dev/location": "EU",
+			})).To(Succeed())
+			Expect(merger.MergeAnnotations(SOURCE_ANNOTATION, map[string]string{
+				"gcs.csi.ofek.dev/location": "US",
+				"gcs.csi.ofek.dev/gid":      "1000",
+			})).To(Succeed())
+			Expect(merger.MergeMountOptions([]string{"--gid=2000", "--uid=1000"})).To(Succeed())
+			Expect(merger.Merge(SOURCE_SECRET, map[string]string{"uid": "0", "projectId": "csi-gcs"})).To(Succeed())
+
+			options := merger.Options()
+			Expect(options).To(Equal(map[string]string{
+				"gid":       "1000",
+				"dirMode":   "0775",
+				"location":  "EU",
+				"uid":       "1000",
+				"projectId": "csi-gcs",
+			}))
+
+			options["bucket"] = "generated"
+			Expect(merger.Sources(options)).To(Equal(map[string]Source{
+				"gid":       SOURCE_ANNOTATION,
+				"dirMode":   SOURCE_DEFAULT,
+				"location":  SOURCE_PARAMETER,
+				"uid":       SOURCE_MOUNT_FLAG,
+				"projectId": SOURCE_SECRET,
+				"bucket":    SOURCE_COMPUTED,
+			}))
+		})
+
+		It("Should merge the attributes of inline volumes", func() {
+			merger := NewMerger(context.Background(), map[string]string{"gid": "63147"})
+			Expect(merger.MergeMountOptions([]string{"--gid=2000"})).To(Succeed())
+			Expect(merger.Merge(SOURCE_INLINE_VOLUME, map[string]string{
+				"bucket":                       "sidecar",
+				"gid":                          "1000",
+				"csi.storage.k8s.io/ephemeral": "true",
+				"csi.storage.k8s.io/pod.name":  "app",
+			})).To(Succeed())
+
+			options := merger.Options()
+			Expect(options).To(Equal(map[string]string{"bucket": "sidecar", "gid": "1000"}))
+			Expect(merger.Sources(options)).To(Equal(map[string]Source{
+				"bucket": SOURCE_INLINE_VOLUME,
+				"gid":    SOURCE_INLINE_VOLUME,
+			}))
+
+			Expect(merger.Merge(SOURCE_INLINE_VOLUME, map[string]string{"cacheDir": "/etc"})).To(MatchError(
+				`invalid inline volume attribute cacheDir="/etc": cannot be set by inline volume attribute`,
+			))
+			Expect(merger.Merge(SOURCE_INLINE_VOLUME, map[string]string{"fuseMountOptions": "cache_dir=/etc"})).To(MatchError(
+				`invalid inline volume attribute fuseMountOptions="cache_dir=/etc": cannot be set by inline volume attribute`,
+			))
+		})
+
+		It("Should only let sources of the administrator pass options to gcsfuse as is", func() {
+			merger := NewMerger(context.Background(), map[string]string{})
+			Expect(merger.MergeAnnotations(SOURCE_ANNOTATION, map[string]string{
+				"gcs.csi.ofek.dev/fuse-mount-options": "key_file=/tmp/keys/other",
+			})).To(MatchError(
+				`invalid annotation gcs.csi.ofek.dev/fuse-mount-options="key_file=/tmp/keys/other": cannot be set by annotation`,
+			))
+
+			Expect(merger.Merge(SOURCE_PARAMETER, map[string]string{"fuseMountOptions": "allow_other"})).To(Succeed())
+			Expect(merger.Options()).To(Equal(map[string]string{"fuseMountOptions": "allow_other"}))
+		})
+
+		It("Should prefer explicit gid options over the volume mount group", func() {
+			merger := NewMerger(context.Background(), map[string]string{"gid": "63147"})
+			Expect(merger.MergeVolumeMountGroup("2000")).To(Succeed())
+			Expect(merger.Options()["gid"]).To(Equal("2000"))
+			Expect(merger.Sources(merger.Options())["gid"]).To(Equal(SOURCE_FS_GROUP))
+
+			Expect(merger.MergeVolumeContext(map[string]string{"gid": "1000"})).To(Succeed())
+			Expect(merger.MergeVolumeMountGroup("3000")).To(Succeed())
+			Expect(merger.Options()["gid"]).To(Equal("1000"))
+
+			merger = NewMerger(context.Background(), map[string]string{"gid": "63147"})
+			Expect(merger.MergeMountOptions([]string{"--gid=1000"})).To(Succeed())
+			Expect(merger.MergeVolumeMountGroup("2000")).To(Succeed())
+			Expect(merger.Options()["gid"]).To(Equal("1000"))
+
+			Expect(merger.MergeVolumeMountGroup("")).To(Succeed())
+			Expect(merger.MergeVolumeMountGroup("group")).NotTo(Succeed())
+		})
+
+		It("Should only default the owner to the security context of the pod", func() {
+			merger := NewMerger(context.Background(), map[string]string{"gid": "63147"})
+			Expect(merger.Merge(SOURCE_SECURITY_CONTEXT, map[string]string{"uid": "1000", "gid": "1000"})).To(Succeed())
+			Expect(merger.MergeVolumeMountGroup("2000")).To(Succeed())
+			Expect(merger.Options()).To(Equal(map[string]string{"uid": "1000", "gid": "2000"}))
+
+			Expect(merger.MergeAnnotations(SOURCE_PARAMETER, map[string]string{"gcs.csi.ofek.dev/uid": "0"})).To(Succeed())
+			Expect(merger.Options()).To(Equal(map[string]string{"uid": "0", "gid": "2000"}))
+		})
+
+		It("Should reject annotations of locked options", func() {
+			merger := NewMerger(context.Background(), nil)
+			Expect(merger.Lock("bucket", "billingProject")).To(Succeed())
+			Expect(merger.MergeAnnotations(SOURCE_PARAMETER, map[string]string{
+				"gcs.csi.ofek.dev/bucket": "shared",
+			})).To(Succeed())
+
+			err := merger.MergeAnnotations(SOURCE_ANNOTATION, map[string]string{
+				"gcs.csi.ofek.dev/bucket": "other",
+			})
+			Expect(err).To(BeAssignableToTypeOf(&LockedOptionError{}))
+			Expect(merger.Options()["bucket"]).To(Equal("shared"))
+
+			Expect(merger.Lock("foo")).NotTo(Succeed())
+		})
+
+		It("Should keep the sources recorded in the volume context", func() {
+			merger := NewMerger(context.Background(), map[string]string{"gid": "63147"})
+			Expect(merger.MergeVolumeContext(map[string]string{
+				"bucket":                      "test",
+				"gid":                         "1000",
+				"csi.storage.k8s.io/pod.name": "pod",
+				VOLUME_CONTEXT_OPTION_SOURCES: "bucket=computed,gid=annotation",
+			})).To(Succeed())
+			Expect(merger.Sources(merger.Options())).To(Equal(map[string]Source{
+				"bucket": SOURCE_COMPUTED,
+				"gid":    SOURCE_ANNOTATION,
+			}))
+		})
+	})
+
+	Describe("AnnotationPolicy", func() {
+		It("Should only allow listed options and values", func() {
+			policy, err := ParseAnnotationPolicy("dirMode, bucket=team-a-[a-z]+")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(policy.Check(map[string]string{
+				"gcs.csi.ofek.dev/dir-mode": "0700",
+				"gcs.csi.ofek.dev/bucket":   "team-a-data",
+				"example.com/owner":         "team-a",
+			})).To(Succeed())
+			Expect(policy.Check(map[string]string{
+				"gcs.csi.ofek.dev/bucket": "team-b-data",
+			})).To(MatchError(`annotation gcs.csi.ofek.dev/bucket="team-b-data" is not allowed: value does not match ^(?:team-a-[a-z]+)$`))
+			Expect(policy.Check(map[string]string{
+				"gcs.csi.ofek.dev/project-id": "other",
+			})).To(MatchError(`annotation gcs.csi.ofek.dev/project-id="other" is not allowed: option is not in the allowed annotations`))
+		})
+
+		It("Should reject unknown options and invalid patterns", func() {
+			_, err := ParseAnnotationPolicy("foo")
+			Expect(err).To(HaveOccurred())
+			_, err = ParseAnnotationPolicy("bucket=team-(")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should be enforced by the merger", func() {
+			policy, _ := ParseAnnotationPolicy("gid")
+			merger := NewMerger(context.Background(), nil)
+			merger.Restrict(policy)
+
+			err := merger.MergeAnnotations(SOURCE_ANNOTATION, map[string]string{"gcs.csi.ofek.dev/bucket": "other"})
+			Expect(IsDenied(err)).To(BeTrue())
+			Expect(merger.MergeAnnotations(SOURCE_PARAMETER, map[string]string{"gcs.csi.ofek.dev/bucket": "shared"})).To(Succeed())
+			Expect(IsDenied(merger.MergeAnnotations(SOURCE_ANNOTATION, map[string]string{"gcs.csi.ofek.dev/dir-mode": "999"}))).To(BeTrue())
+			Expect(merger.MergeAnnotations(SOURCE_ANNOTATION, map[string]string{"gcs.csi.ofek.dev/gid": "1000"})).To(Succeed())
+		})
+	})
+
+	Describe("FormatSources", func() {
+		It("Should round trip", func() {
+			sources := map[string]Source{"gid": SOURCE_MOUNT_FLAG, "bucket": SOURCE_PARAMETER}
+			Expect(FormatSources(sources)).To(Equal("bucket=StorageClass parameter,gid=mount flag"))
+			Expect(ParseSources(FormatSources(sources))).To(Equal(sources))
+		})
+	})
+
+	Describe("ParseLockedOptions", func() {
+		It("Should only accept known options", func() {
+			Expect(ParseLockedOptions(" bucket, billingProject,")).To(Equal([]string{"bucket", "billingProject"}))
+			_, err := ParseLockedOptions("bucket,foo")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,605 @@
+package flags
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ofek/csi-gcs/pkg/util"
+)
+
+// Type is the type of the value of an option.
+type Type string
+
+const (
+	TYPE_TEXT      Type = "Text"
+	TYPE_TEXT_LIST Type = "Text[]"
+	TYPE_FLAG      Type = "Flag"
+	TYPE_OCTAL     Type = "Octal Integer"
+	TYPE_ID        Type = "ID"
+	TYPE_INTEGER   Type = "Integer"
+	TYPE_NUMBER    Type = "Number"
+	TYPE_DURATION  Type = "Duration"
+	TYPE_QUANTITY  Type = "Quantity"
+	TYPE_BUCKET    Type = "Bucket"
+	TYPE_LOCATION  Type = "Location"
+	TYPE_KMS_KEY   Type = "KMS Key"
+	TYPE_PATH      Type = "Path"
+	TYPE_ENUM      Type = "Enum"
+)
+
+// Scope is the set of driver components that consume an option.
+type Scope int
+
+const (
+	SCOPE_CONTROLLER Scope = 1 << iota
+	SCOPE_NODE
+)
+
+func (s Scope) String() string {
+	switch s {
+	case SCOPE_CONTROLLER:
+		return "Controller"
+	case SCOPE_NODE:
+		return "Node"
+	case SCOPE_CONTROLLER | SCOPE_NODE:
+		return "Controller, Node"
+	}
+	return ""
+}
+
+// Option describes an option and every way it may be set.
+type Option struct {
+	// Name is the key used in secrets, StorageClass parameters and the volume context.
+	Name string
+	// Annotation is the PVC annotation and prefixed StorageClass parameter, derived from Name unless set.
+	Annotation string
+	// MountOption is the name of the mount flag without dashes, derived from Name unless set.
+	MountOption string
+	// GcsfuseOption is the gcsfuse mount option the value is rendered as, if any.
+	GcsfuseOption string
+	// ConfigKey is the dotted path of the value in a gcsfuse config file, if any.
+	ConfigKey string
+	Type      Type
+	// Default is applied by the components in Scope when the option is not set.
+	Default string
+	// Min and Max bound the values of TYPE_INTEGER options, a Max of 0 is unbounded.
+	Min int64
+	Max int64
+	// Values are the allowed values of TYPE_ENUM options.
+	Values []string
+	// IntegerUnit is the unit of bare integers given to TYPE_DURATION options that were integers in older releases.
+	IntegerUnit time.Duration
+	// Validator further validates values that are valid for the Type, e.g. the entries of TYPE_TEXT_LIST options.
+	Validator func(value string) error
+	// Sources that may set the option, nil allows every source.
+	Sources     []Source
+	Scope       Scope
+	Description string
+}
+
+// AllowsSource reports whether the option may be set by the source.
+func (o *Option) AllowsSource(source Source) bool {
+	if o.Sources == nil || source == SOURCE_DEFAULT {
+		return true
+	}
+	for _, s := range o.Sources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate validates a value of the option.
+func (o *Option) Validate(value string) error {
+	if err := o.validateType(value); err != nil {
+		return err
+	}
+	if o.Validator != nil {
+		return o.Validator(value)
+	}
+
+	return nil
+}
+
+// validateType validates a value against the type of the option.
+func (o *Option) validateType(value string) error {
+	switch o.Type {
+	case TYPE_BUCKET:
+		if value == "" {
+			return nil
+		}
+		return util.ValidateBucketName(value)
+	case TYPE_LOCATION:
+		if value != "" && !locationPattern.MatchString(value) {
+			return fmt.Errorf("not a bucket location such as US or us-central1")
+		}
+	case TYPE_KMS_KEY:
+		if value != "" && !kmsKeyPattern.MatchString(value) {
+			return fmt.Errorf("expected projects/<project>/locations/<location>/keyRings/<key ring>/cryptoKeys/<key>")
+		}
+	case TYPE_OCTAL:
+		_, err := parseMode(value)
+		return err
+	case TYPE_ID:
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id < -1 || id >= math.MaxUint32 {
+			return fmt.Errorf("expected an integer between -1 and %d", uint32(math.MaxUint32-1))
+		}
+	case TYPE_FLAG:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("expected true or false")
+		}
+	case TYPE_NUMBER:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || (number < 0 && number != -1) {
+			return fmt.Errorf("expected a non-negative number or -1 for no limit")
+		}
+	case TYPE_DURATION:
+		duration, err := o.parseDuration(value)
+		if err != nil || duration < 0 {
+			return fmt.Errorf("expected a non-negative duration such as 30s or 1h")
+		}
+	case TYPE_QUANTITY:
+		_, err := util.ParseCapacity(value)
+		return err
+	case TYPE_INTEGER:
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || number < o.Min || (o.Max != 0 && number > o.Max) {
+			if o.Max != 0 {
+				return fmt.Errorf("expected an integer between %d and %d", o.Min, o.Max)
+			}
+			return fmt.Errorf("expected an integer of at least %d", o.Min)
+		}
+	case TYPE_PATH:
+		if !path.IsAbs(value) || path.Clean(value) != value {
+			return fmt.Errorf("expected a clean absolute path")
+		}
+	case TYPE_ENUM:
+		for _, allowed := range o.Values {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("expected one of %s", strings.Join(o.Values, ", "))
+	}
+
+	return nil
+}
+
+// normalize converts a value to the form stored in the options.
+func (o *Option) normalize(value string) string {
+	switch o.Type {
+	case TYPE_OCTAL:
+		if mode, err := parseMode(value); err == nil {
+			return "0" + strconv.FormatInt(int64(mode), 8)
+		}
+	case TYPE_DURATION:
+		if _, err := strconv.ParseInt(value, 10, 64); err == nil && o.IntegerUnit != 0 {
+			if duration, err := o.parseDuration(value); err == nil {
+				return duration.String()
+			}
+		}
+	}
+	return value
+}
+
+// parseDuration parses a value of a TYPE_DURATION option, bare integers are taken in IntegerUnit if it is set.
+func (o *Option) parseDuration(value string) (time.Duration, error) {
+	if o.IntegerUnit != 0 {
+		if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.Duration(number) * o.IntegerUnit, nil
+		}
+	}
+
+	return time.ParseDuration(value)
+}
+
+var (
+	// provisionerSources are the sources controlled by the cluster administrator.
+	provisionerSources = []Source{SOURCE_SECRET, SOURCE_MOUNT_FLAG, SOURCE_PARAMETER}
+	// hostSources are the sources allowed to refer to paths of the node.
+	hostSources = []Source{SOURCE_SECRET, SOURCE_MOUNT_FLAG, SOURCE_PARAMETER, SOURCE_VOLUME_CONTEXT}
+)
+
+// Registry is every option known to the driver, in the order they are rendered.
+var Registry = []Option{
+	{
+		Name:        FLAG_BUCKET,
+		Type:        TYPE_BUCKET,
+		Scope:       SCOPE_CONTROLLER | SCOPE_NODE,
+		Description: "The name of the bucket.",
+	},
+	{
+		Name:        FLAG_PROJECT_ID,
+		Type:        TYPE_TEXT,
+		Scope:       SCOPE_CONTROLLER,
+		Description: "The project to create the buckets in.",
+	},
+	{
+		Name:        FLAG_KMS_KEY_ID,
+		Type:        TYPE_KMS_KEY,
+		Scope:       SCOPE_CONTROLLER,
+		Description: "KMS encryption key ID. (projects/my-pet-project/locations/us-east1/keyRings/my-key-ring/cryptoKeys/my-key)",
+	},
+	{
+		Name:        FLAG_LOCATION,
+		Type:        TYPE_LOCATION,
+		Scope:       SCOPE_CONTROLLER,
+		Description: "The [location][gcs-location] to create buckets at, by default taken from the [topology](dynamic_provisioning.md#topology).",
+	},
+	{
+		Name:        FLAG_FUSE_MOUNT_OPTION,
+		MountOption: "fuse-mount-option", // singular unlike the name, as it has always been
+		Type:        TYPE_TEXT_LIST,
+		// Passed to gcsfuse as is, so they may set any of its paths, e.g. cache_dir or key_file
+		Sources:     hostSources,
+		Scope:       SCOPE_NODE,
+		Description: "Additional comma-separated system-specific [mount options][fuse-mount-options]. Be careful!",
+	},
+	{
+		Name:          FLAG_DIR_MODE,
+		GcsfuseOption: "dir_mode",
+		ConfigKey:     "file-system.dir-mode",
+		Type:          TYPE_OCTAL,
+		Default:       "0775",
+		Scope:         SCOPE_NODE,
+		Description:   "Permission bits for directories.",
+	},
+	{
+		Name:          FLAG_FILE_MODE,
+		GcsfuseOption: "file_mode",
+		ConfigKey:     "file-system.file-mode",
+		Type:          TYPE_OCTAL,
+		Default:       "0664",
+		Scope:         SCOPE_NODE,
+		Description:   "Permission bits for files.",
+	},
+	{
+		Name:          FLAG_UID,
+		GcsfuseOption: "uid",
+		ConfigKey:     "file-system.uid",
+		Type:          TYPE_ID,
+		Scope:         SCOPE_NODE,
+		Description:   "UID owner of all inodes, -1 for the user running gcsfuse.",
+	},
+	{
+		Name:          FLAG_GID,
+		GcsfuseOption: "gid",
+		ConfigKey:     "file-system.gid",
+		Type:          TYPE_ID,
+		Default:       "63147",
+		Scope:         SCOPE_NODE,
+		Description:   "GID owner of all inodes. Defaults to the `fsGroup` of the pod if it has one.",
+	},
+	{
+		Name:        FLAG_OWNER_FROM_POD,
+		Type:        TYPE_FLAG,
+		Scope:       SCOPE_NODE,
+		Description: "Default `uid` and `gid` to the effective `runAsUser` and `runAsGroup` of the pod.",
+	},
+	{
+		Name:          FLAG_IMPLICIT_DIRS,
+		GcsfuseOption: "implicit_dirs",
+		ConfigKey:     "implicit-dirs",
+		Type:          TYPE_FLAG,
+		Scope:         SCOPE_NODE,
+		Description:   "[Implicitly][gcsfuse-implicit-dirs] define directories based on content.",
+	},
+	{
+		Name:          FLAG_BILLING_PROJECT,
+		GcsfuseOption: "billing_project",
+		ConfigKey:     "gcs-connection.billing-project",
+		Type:          TYPE_TEXT,
+		Scope:         SCOPE_NODE,
+		Description:   "Project to use for billing when accessing requester pays buckets.",
+	},
+	{
+		Name:          FLAG_LIMIT_BYTES_PER_SEC,
+		GcsfuseOption: "limit_bytes_per_sec",
+		ConfigKey:     "gcs-connection.limit-bytes-per-sec",
+		Type:          TYPE_NUMBER,
+		Scope:         SCOPE_NODE,
+		Description:   "Bandwidth limit for reading data, measured over a 30-second window. The default is -1 (no limit).",
+	},
+	{
+		Name:          FLAG_LIMIT_OPS_PER_SEC,
+		GcsfuseOption: "limit_ops_per_sec",
+		ConfigKey:     "gcs-connection.limit-ops-per-sec",
+		Type:          TYPE_NUMBER,
+		Scope:         SCOPE_NODE,
+		Description:   "Operations per second limit, measured over a 30-second window. The default is 5. Use -1 for no limit.",
+	},
+	{
+		Name:          FLAG_STAT_CACHE_TTL,
+		GcsfuseOption: "stat_cache_ttl",
+		Type:          TYPE_DURATION,
+		Scope:         SCOPE_NODE,
+		Description:   "How long to cache StatObject results and inode attributes e.g. `1h`.",
+	},
+	{
+		Name:          FLAG_TYPE_CACHE_TTL,
+		GcsfuseOption: "type_cache_ttl",
+		Type:          TYPE_DURATION,
+		Scope:         SCOPE_NODE,
+		Description:   "How long to cache name -> file/dir mappings in directory inodes e.g. `1h`.",
+	},
+	{
+		Name:          FLAG_MAX_RETRY_SLEEP,
+		GcsfuseOption: "max_retry_sleep",
+		ConfigKey:     "gcs-retries.max-retry-sleep",
+		Type:          TYPE_DURATION,
+		IntegerUnit:   time.Minute,
+		Scope:         SCOPE_NODE,
+		Description:   "The maximum duration allowed to sleep in a retry loop with exponential backoff for failed requests to GCS backend. Once the backoff duration exceeds this limit, the retry stops. The default is 1 minute. A value of 0 disables retries. For compatibility, an integer is taken as minutes.",
+	},
+	{
+		Name:        FLAG_PARENT_BUCKET,
+		Type:        TYPE_BUCKET,
+		Sources:     provisionerSources,
+		Scope:       SCOPE_CONTROLLER,
+		Description: "An existing bucket in which volumes are provisioned as [sub-directories](dynamic_provisioning.md#sub-directory-volumes) instead of new buckets.",
+	},
+	{
+		Name:          FLAG_ONLY_DIR,
+		GcsfuseOption: "only_dir",
+		ConfigKey:     "only-dir",
+		Type:          TYPE_TEXT,
+		Scope:         SCOPE_NODE,
+		Description:   "Mount only the given directory of the bucket.",
+	},
+	{
+		Name:        FLAG_BUCKET_NAME_TEMPLATE,
+		Type:        TYPE_TEXT,
+		Sources:     provisionerSources,
+		Scope:       SCOPE_CONTROLLER,
+		Description: "The [template](dynamic_provisioning.md#bucket-names) for the names of created buckets.",
+	},
+	{
+		Name:        FLAG_CAPACITY_BUDGET,
+		Type:        TYPE_QUANTITY,
+		Sources:     provisionerSources,
+		Scope:       SCOPE_CONTROLLER,
+		Description: "The total capacity of the buckets the driver may provision in the project, see [capacity budgets](csi_compatibility.md#capacity-budgets).",
+	},
+	{
+		Name:          FLAG_CACHE_DIR,
+		GcsfuseOption: "cache_dir",
+		ConfigKey:     "cache-dir",
+		Type:          TYPE_PATH,
+		Sources:       hostSources,
+		Scope:         SCOPE_NODE,
+		Description:   "Directory of the node plugin in which the file cache is stored, enables the file cache.",
+	},
+	{
+		Name:          FLAG_FILE_CACHE_MAX_SIZE_MB,
+		GcsfuseOption: "file_cache_max_size_mb",
+		ConfigKey:     "file-cache.max-size-mb",
+		Type:          TYPE_INTEGER,
+		Min:           -1,
+		Scope:         SCOPE_NODE,
+		Description:   "Maximum size of the file cache in MiB. Use -1 for no limit.",
+	},
+	{
+		Name:          FLAG_TEMP_DIR,
+		GcsfuseOption: "temp_dir",
+		ConfigKey:     "file-system.temp-dir",
+		Type:          TYPE_PATH,
+		Sources:       hostSources,
+		Scope:         SCOPE_NODE,
+		Description:   "Directory of the node plugin in which writes are staged before being uploaded.",
+	},
+	{
+		Name:          FLAG_SEQUENTIAL_READ_SIZE_MB,
+		GcsfuseOption: "sequential_read_size_mb",
+		ConfigKey:     "gcs-connection.sequential-read-size-mb",
+		Type:          TYPE_INTEGER,
+		Min:           1,
+		Max:           1024,
+		Scope:         SCOPE_NODE,
+		Description:   "Size in MiB of the chunks downloaded from GCS while reading sequentially.",
+	},
+	{
+		Name:          FLAG_KERNEL_LIST_CACHE_TTL_SECS,
+		GcsfuseOption: "kernel_list_cache_ttl_secs",
+		ConfigKey:     "file-system.kernel-list-cache-ttl-secs",
+		Type:          TYPE_INTEGER,
+		Min:           -1,
+		Scope:         SCOPE_NODE,
+		Description:   "How many seconds the kernel caches directory listings. Use 0 to disable the cache and -1 to never expire entries.",
+	},
+	{
+		Name:          FLAG_MAX_CONNS_PER_HOST,
+		GcsfuseOption: "max_conns_per_host",
+		ConfigKey:     "gcs-connection.max-conns-per-host",
+		Type:          TYPE_INTEGER,
+		Scope:         SCOPE_NODE,
+		Description:   "Maximum number of TCP connections to GCS. Use 0 for no limit.",
+	},
+	{
+		Name:          FLAG_CLIENT_PROTOCOL,
+		GcsfuseOption: "client_protocol",
+		ConfigKey:     "gcs-connection.client-protocol",
+		Type:          TYPE_ENUM,
+		Values:        []string{"http1", "http2", "grpc"},
+		Scope:         SCOPE_NODE,
+		Description:   "The protocol used to communicate with GCS.",
+	},
+	{
+		Name:          FLAG_RENAME_DIR_LIMIT,
+		GcsfuseOption: "rename_dir_limit",
+		ConfigKey:     "file-system.rename-dir-limit",
+		Type:          TYPE_INTEGER,
+		Scope:         SCOPE_NODE,
+		Description:   "Allow renaming directories containing fewer descendants than this limit.",
+	},
+	{
+		Name:          FLAG_ENABLE_HNS,
+		GcsfuseOption: "enable_hns",
+		ConfigKey:     "enable-hns",
+		Type:          TYPE_FLAG,
+		Scope:         SCOPE_NODE,
+		Description:   "Use the hierarchical namespace of buckets that have it enabled.",
+	},
+	{
+		Name:          FLAG_LOG_SEVERITY,
+		GcsfuseOption: "log_severity",
+		ConfigKey:     "logging.severity",
+		Type:          TYPE_ENUM,
+		Values:        []string{"trace", "debug", "info", "warning", "error", "off"},
+		Scope:         SCOPE_NODE,
+		Description:   "Minimum severity of the messages logged by gcsfuse.",
+	},
+	{
+		Name:          FLAG_LOG_FORMAT,
+		GcsfuseOption: "log_format",
+		ConfigKey:     "logging.format",
+		Type:          TYPE_ENUM,
+		Values:        []string{"text", "json"},
+		Scope:         SCOPE_NODE,
+		Description:   "Format of the messages logged by gcsfuse.",
+	},
+	{
+		Name:        FLAG_LOCKED_OPTIONS,
+		Type:        TYPE_TEXT_LIST,
+		Validator:   validateLockedOptions,
+		Sources:     provisionerSources,
+		Scope:       SCOPE_CONTROLLER,
+		Description: "Comma-separated names of options that PersistentVolumeClaim annotations may not set, in addition to the driver's `--locked-options` flag.",
+	},
+	{
+		Name:        FLAG_ALLOWED_ANNOTATIONS,
+		Type:        TYPE_TEXT_LIST,
+		Validator:   validateAnnotationPolicy,
+		Sources:     provisionerSources,
+		Scope:       SCOPE_CONTROLLER,
+		Description: "Comma-separated names of the only options that PersistentVolumeClaim annotations may set, each optionally followed by `=<pattern>` that values must match. Overrides the driver's `--allowed-annotations` flag, see [annotation policy](dynamic_provisioning.md#annotation-policy).",
+	},
+}
+
+var (
+	optionsByName        = map[string]*Option{}
+	optionsByAnnotation  = map[string]*Option{}
+	optionsByMountOption = map[string]*Option{}
+)
+
+func init() {
+	for i := range Registry {
+		option := &Registry[i]
+		if option.Annotation == "" {
+			option.Annotation = ANNOTATION_PREFIX + kebabCase(option.Name)
+		}
+		if option.MountOption == "" {
+			option.MountOption = kebabCase(option.Name)
+		}
+
+		optionsByName[option.Name] = option
+		optionsByAnnotation[option.Annotation] = option
+		optionsByMountOption[option.MountOption] = option
+	}
+}
+
+// kebabCase converts a camel case name to lowercase words separated by dashes, keeping acronyms together, e.g.
+// kernelListCacheTTLSecs to kernel-list-cache-ttl-secs.
+func kebabCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			previousLower := unicode.IsLower(runes[i-1])
+			endsAcronym := unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if previousLower || endsAcronym {
+				b.WriteByte('-')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+
+	return b.String()
+}
+
+// LookupOption returns the option of the given flag name.
+func LookupOption(name string) (*Option, bool) {
+	option, found := optionsByName[name]
+	return option, found
+}
+
+// Defaults returns the default values of the options consumed by the scope.
+func Defaults(scope Scope) map[string]string {
+	defaults := map[string]string{}
+	for _, option := range Registry {
+		if option.Default != "" && option.Scope&scope != 0 {
+			defaults[option.Name] = option.Default
+		}
+	}
+
+	return defaults
+}
+
+// VolumeContext returns the options that may be forwarded to the volume context.
+func VolumeContext(flags map[string]string) map[string]string {
+	result := map[string]string{}
+	for k, v := range flags {
+		if option, found := LookupOption(k); found && option.AllowsSource(SOURCE_VOLUME_CONTEXT) {
+			result[k] = v
+		}
+	}
+
+	return result
+}
+
+// MarkdownReference renders the reference documentation of every option.
+func MarkdownReference() string {
+	var b strings.Builder
+
+	b.WriteString("# Options\n\n---\n\n")
+	b.WriteString("<!-- Generated by `go run ./hack/gen-options-doc`, do not edit. -->\n\n")
+	b.WriteString("Every option may be set by any of the following, unless restricted by its sources:\n\n")
+	b.WriteString("- `secret`: a key of the provisioner or node publish secret\n")
+	b.WriteString("- `mount flag`: a `--<mount option>` entry of `mountOptions`\n")
+	b.WriteString("- `annotation`: a PersistentVolumeClaim annotation\n")
+	b.WriteString("- `StorageClass parameter`: a StorageClass parameter, keyed by the annotation\n")
+	b.WriteString("- `volume context`: a key of `PersistentVolume.spec.csi.volumeAttributes`\n")
+	b.WriteString("- `inline volume attribute`: a key of the `volumeAttributes` of an inline `csi` volume of a pod\n\n")
+	b.WriteString("| Name | Annotation | Mount option | Type | Default | Sources | Scope | Description |\n")
+	b.WriteString("| --- | --- | --- | --- | --- | --- | --- | --- |\n")
+
+	for _, option := range Registry {
+		sources := "any"
+		if option.Sources != nil {
+			names := make([]string, len(option.Sources))
+			for i, source := range option.Sources {
+				names[i] = string(source)
+			}
+			sources = strings.Join(names, ", ")
+		}
+
+		typeName := string(option.Type)
+		switch {
+		case option.Type == TYPE_ENUM:
+			typeName += " (`" + strings.Join(option.Values, "`, `") + "`)"
+		case option.Type == TYPE_INTEGER && option.Max != 0:
+			typeName += fmt.Sprintf(" (%d to %d)", option.Min, option.Max)
+		case option.Type == TYPE_INTEGER:
+			typeName += fmt.Sprintf(" (at least %d)", option.Min)
+		}
+
+		defaultValue := ""
+		if option.Default != "" {
+			defaultValue = "`" + option.Default + "`"
+		}
+
+		fmt.Fprintf(
+			&b, "| `%s` | `%s` | `%s` | %s | %s | %s | %s | %s |\n",
+			option.Name, option.Annotation, option.MountOption, typeName,
+			defaultValue, sources, option.Scope, option.Description,
+		)
+	}
+
+	return b.String()
+}
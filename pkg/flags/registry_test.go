@@ -0,0 +1,99 @@
+package flags_test
+
+import (
+	"io/ioutil"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/ofek/csi-gcs/pkg/flags"
+)
+
+var _ = Describe("Registry", func() {
+	It("Should have unique names", func() {
+		names := map[string]bool{}
+		for _, option := range Registry {
+			for _, name := range []string{option.Name, option.Annotation, "--" + option.MountOption} {
+				Expect(names).NotTo(HaveKey(name))
+				names[name] = true
+			}
+			Expect(IsOwnAnnotation(option.Annotation)).To(BeTrue(), option.Annotation)
+			Expect(option.Scope).NotTo(BeZero(), option.Name)
+			if option.Default != "" {
+				Expect(option.Validate(option.Default)).To(Succeed(), option.Name)
+			}
+		}
+	})
+
+	It("Should derive annotations and mount options from the name", func() {
+		for name, keys := range map[string][2]string{
+			FLAG_PROJECT_ID:                 {"gcs.csi.ofek.dev/project-id", "project-id"},
+			FLAG_KERNEL_LIST_CACHE_TTL_SECS: {"gcs.csi.ofek.dev/kernel-list-cache-ttl-secs", "kernel-list-cache-ttl-secs"},
+			FLAG_FILE_CACHE_MAX_SIZE_MB:     {"gcs.csi.ofek.dev/file-cache-max-size-mb", "file-cache-max-size-mb"},
+			FLAG_ENABLE_HNS:                 {"gcs.csi.ofek.dev/enable-hns", "enable-hns"},
+			FLAG_FUSE_MOUNT_OPTION:          {"gcs.csi.ofek.dev/fuse-mount-options", "fuse-mount-option"},
+		} {
+			option, found := LookupOption(name)
+			Expect(found).To(BeTrue(), name)
+			Expect(option.Annotation).To(Equal(keys[0]))
+			Expect(option.MountOption).To(Equal(keys[1]))
+		}
+	})
+
+	It("Should validate values with the validator of the option", func() {
+		option, _ := LookupOption(FLAG_LOCKED_OPTIONS)
+		Expect(option.Validate("bucket,uid")).To(Succeed())
+		Expect(option.Validate("bucket,unknown")).To(MatchError(`cannot lock unknown option "unknown"`))
+
+		option, _ = LookupOption(FLAG_ALLOWED_ANNOTATIONS)
+		Expect(option.Validate("uid=[(")).To(HaveOccurred())
+	})
+
+	It("Should restrict sources", func() {
+		Expect(ValidateAnnotations(SOURCE_PARAMETER, map[string]string{
+			"gcs.csi.ofek.dev/capacity-budget": "10Ti",
+		})).To(Succeed())
+		Expect(ValidateAnnotations(SOURCE_ANNOTATION, map[string]string{
+			"gcs.csi.ofek.dev/capacity-budget": "10Ti",
+		})).To(MatchError(`invalid annotation gcs.csi.ofek.dev/capacity-budget="10Ti": cannot be set by annotation`))
+		Expect(ValidateAnnotations(SOURCE_ANNOTATION, map[string]string{
+			"gcs.csi.ofek.dev/cache-dir": "/etc",
+		})).To(MatchError(`invalid annotation gcs.csi.ofek.dev/cache-dir="/etc": cannot be set by annotation`))
+		Expect(ValidateFlags(SOURCE_VOLUME_CONTEXT, map[string]string{
+			"cacheDir": "/var/cache/gcsfuse",
+		})).To(Succeed())
+	})
+
+	Describe("Defaults", func() {
+		It("Should only include the scope", func() {
+			Expect(Defaults(SCOPE_NODE)).To(Equal(map[string]string{
+				"gid":      "63147",
+				"dirMode":  "0775",
+				"fileMode": "0664",
+			}))
+			Expect(Defaults(SCOPE_CONTROLLER)).To(BeEmpty())
+		})
+	})
+
+	Describe("VolumeContext", func() {
+		It("Should drop options not allowed in the volume context", func() {
+			Expect(VolumeContext(map[string]string{
+				"bucket":             "test",
+				"location":           "US",
+				"bucketNameTemplate": "{{ .PVCName }}",
+				"capacityBudget":     "10Ti",
+			})).To(Equal(map[string]string{
+				"bucket":   "test",
+				"location": "US",
+			}))
+		})
+	})
+
+	Describe("MarkdownReference", func() {
+		It("Should match the generated documentation", func() {
+			doc, err := ioutil.ReadFile("../../docs/options.md")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(doc)).To(Equal(MarkdownReference()), "run `go run ./hack/gen-options-doc`")
+		})
+	})
+})
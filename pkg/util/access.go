@@ -0,0 +1,105 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/ofek/csi-gcs/pkg/apis/published-volume/v1beta1"
+	gcs "github.com/ofek/csi-gcs/pkg/client/clientset/clientset"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// BucketAccessAllowed reports whether any policy selecting the namespace allows mounting the bucket. A policy with
+// mode ReadOnly only allows read-only mounts. The reason describes why access is denied.
+func BucketAccessAllowed(policies []v1beta1.BucketAccessPolicy, namespace *corev1.Namespace, bucket string, readOnly bool) (allowed bool, reason string, err error) {
+	readOnlyOnly := false
+
+	for _, policy := range policies {
+		selected, err := policySelectsNamespace(&policy, namespace)
+		if err != nil {
+			return false, "", fmt.Errorf("invalid bucket access policy %s: %v", policy.Name, err)
+		}
+		if !selected {
+			continue
+		}
+
+		matched, err := policyMatchesBucket(&policy, bucket)
+		if err != nil {
+			return false, "", fmt.Errorf("invalid bucket access policy %s: %v", policy.Name, err)
+		}
+		if !matched {
+			continue
+		}
+
+		switch policy.Spec.Mode {
+		case v1beta1.BucketAccessReadWrite:
+			return true, "", nil
+		case v1beta1.BucketAccessReadOnly:
+			if readOnly {
+				return true, "", nil
+			}
+			readOnlyOnly = true
+		default:
+			return false, "", fmt.Errorf("invalid bucket access policy %s: unknown mode %q", policy.Name, policy.Spec.Mode)
+		}
+	}
+
+	if readOnlyOnly {
+		return false, fmt.Sprintf("namespace %s may only mount bucket %s read-only", namespace.Name, bucket), nil
+	}
+
+	return false, fmt.Sprintf("no bucket access policy allows namespace %s to mount bucket %s", namespace.Name, bucket), nil
+}
+
+func policySelectsNamespace(policy *v1beta1.BucketAccessPolicy, namespace *corev1.Namespace) (bool, error) {
+	for _, name := range policy.Spec.Namespaces {
+		if name == namespace.Name {
+			return true, nil
+		}
+	}
+
+	if policy.Spec.NamespaceSelector == nil {
+		return false, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(policy.Spec.NamespaceSelector)
+	if err != nil {
+		return false, err
+	}
+
+	return selector.Matches(labels.Set(namespace.Labels)), nil
+}
+
+func policyMatchesBucket(policy *v1beta1.BucketAccessPolicy, bucket string) (bool, error) {
+	for _, pattern := range policy.Spec.Buckets {
+		matched, err := path.Match(pattern, bucket)
+		if err != nil {
+			return false, fmt.Errorf("bucket pattern %q: %v", pattern, err)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// CheckBucketAccess evaluates the bucket access policies of the cluster for a mount of the bucket by a pod of the
+// namespace, see BucketAccessAllowed.
+func CheckBucketAccess(ctx context.Context, clientset kubernetes.Interface, gcsClientset gcs.Interface, namespaceName string, bucket string, readOnly bool) (allowed bool, reason string, err error) {
+	namespace, err := clientset.CoreV1().Namespaces().Get(ctx, namespaceName, metav1.GetOptions{})
+	if err != nil {
+		return false, "", err
+	}
+
+	policies, err := gcsClientset.GcsV1beta1().BucketAccessPolicies().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, "", err
+	}
+
+	return BucketAccessAllowed(policies.Items, namespace, bucket, readOnly)
+}
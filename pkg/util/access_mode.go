@@ -0,0 +1,40 @@
+package util
+
+import (
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// IsReadOnlyAccessMode reports whether the access mode only allows reading, e.g. ReadOnlyMany.
+func IsReadOnlyAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	return mode == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY ||
+		mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+}
+
+// IsSingleWriterAccessMode reports whether the access mode allows a single writer across the cluster, i.e. ReadWriteOncePod.
+func IsSingleWriterAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	return mode == csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER
+}
+
+// ValidateVolumeCapabilities returns an error describing the first capability, or combination of capabilities,
+// that the driver does not support.
+func ValidateVolumeCapabilities(capabilities []*csi.VolumeCapability) error {
+	for _, capability := range capabilities {
+		if capability.GetMount() == nil || capability.GetBlock() != nil {
+			return fmt.Errorf("Only volumeMode Filesystem is supported")
+		}
+
+		mode := capability.GetAccessMode().GetMode()
+		if _, known := csi.VolumeCapability_AccessMode_Mode_name[int32(mode)]; !known || mode == csi.VolumeCapability_AccessMode_UNKNOWN {
+			return fmt.Errorf("Access mode %s is not supported", mode)
+		}
+
+		// Like ReadWriteOncePod, the single writer mode may not be combined with other modes
+		if IsSingleWriterAccessMode(mode) && len(capabilities) > 1 {
+			return fmt.Errorf("Access mode %s cannot be combined with other access modes", mode)
+		}
+	}
+
+	return nil
+}
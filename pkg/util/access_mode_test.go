@@ -0,0 +1,61 @@
+package util_test
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/ofek/csi-gcs/pkg/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AccessMode", func() {
+	capability := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+		}
+	}
+
+	Describe("IsReadOnlyAccessMode", func() {
+		It("should only match reader modes", func() {
+			Expect(IsReadOnlyAccessMode(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY)).To(BeTrue())
+			Expect(IsReadOnlyAccessMode(csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY)).To(BeTrue())
+			Expect(IsReadOnlyAccessMode(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)).To(BeFalse())
+			Expect(IsReadOnlyAccessMode(csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER)).To(BeFalse())
+		})
+	})
+
+	Describe("ValidateVolumeCapabilities", func() {
+		It("should accept filesystem volumes", func() {
+			Expect(ValidateVolumeCapabilities([]*csi.VolumeCapability{
+				capability(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY),
+				capability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			})).To(Succeed())
+			Expect(ValidateVolumeCapabilities([]*csi.VolumeCapability{
+				capability(csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER),
+			})).To(Succeed())
+		})
+
+		It("should reject block volumes", func() {
+			Expect(ValidateVolumeCapabilities([]*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}})).To(MatchError("Only volumeMode Filesystem is supported"))
+		})
+
+		It("should reject unknown access modes", func() {
+			Expect(ValidateVolumeCapabilities([]*csi.VolumeCapability{
+				capability(csi.VolumeCapability_AccessMode_UNKNOWN),
+			})).To(MatchError("Access mode UNKNOWN is not supported"))
+			Expect(ValidateVolumeCapabilities([]*csi.VolumeCapability{
+				{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}},
+			})).To(MatchError("Access mode UNKNOWN is not supported"))
+		})
+
+		It("should reject the single writer mode combined with other modes", func() {
+			Expect(ValidateVolumeCapabilities([]*csi.VolumeCapability{
+				capability(csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER),
+				capability(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY),
+			})).To(MatchError("Access mode SINGLE_NODE_SINGLE_WRITER cannot be combined with other access modes"))
+		})
+	})
+})
@@ -0,0 +1,106 @@
+package util_test
+
+import (
+	"context"
+
+	"github.com/ofek/csi-gcs/pkg/apis/published-volume/v1beta1"
+	gcsfake "github.com/ofek/csi-gcs/pkg/client/clientset/clientset/fake"
+	. "github.com/ofek/csi-gcs/pkg/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Access", func() {
+	Describe("BucketAccessAllowed", func() {
+		namespace := func(name string, labels map[string]string) *corev1.Namespace {
+			return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+		}
+		policies := []v1beta1.BucketAccessPolicy{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "team-a"},
+				Spec: v1beta1.BucketAccessPolicySpec{
+					Namespaces: []string{"team-a"},
+					Buckets:    []string{"team-a-*"},
+					Mode:       v1beta1.BucketAccessReadWrite,
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "shared"},
+				Spec: v1beta1.BucketAccessPolicySpec{
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"shared-data": "true"}},
+					Buckets:           []string{"shared-*"},
+					Mode:              v1beta1.BucketAccessReadOnly,
+				},
+			},
+		}
+
+		It("should allow buckets matching a policy of the namespace", func() {
+			allowed, _, err := BucketAccessAllowed(policies, namespace("team-a", nil), "team-a-data", false)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(allowed).To(BeTrue())
+		})
+
+		It("should deny buckets not matching a policy of the namespace", func() {
+			allowed, reason, err := BucketAccessAllowed(policies, namespace("team-b", nil), "team-a-data", true)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(allowed).To(BeFalse())
+			Expect(reason).To(Equal("no bucket access policy allows namespace team-b to mount bucket team-a-data"))
+		})
+
+		It("should select namespaces by label", func() {
+			allowed, _, err := BucketAccessAllowed(policies, namespace("team-b", map[string]string{"shared-data": "true"}), "shared-models", true)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(allowed).To(BeTrue())
+		})
+
+		It("should only allow read-only mounts of read-only policies", func() {
+			allowed, reason, err := BucketAccessAllowed(policies, namespace("team-b", map[string]string{"shared-data": "true"}), "shared-models", false)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(allowed).To(BeFalse())
+			Expect(reason).To(Equal("namespace team-b may only mount bucket shared-models read-only"))
+		})
+
+		It("should reject invalid policies", func() {
+			_, _, err := BucketAccessAllowed([]v1beta1.BucketAccessPolicy{{
+				ObjectMeta: metav1.ObjectMeta{Name: "invalid"},
+				Spec: v1beta1.BucketAccessPolicySpec{
+					Namespaces: []string{"team-a"},
+					Buckets:    []string{"["},
+					Mode:       v1beta1.BucketAccessReadWrite,
+				},
+			}}, namespace("team-a", nil), "team-a-data", false)
+			Expect(err).To(MatchError(ContainSubstring("invalid bucket access policy invalid")))
+		})
+	})
+
+	Describe("CheckBucketAccess", func() {
+		It("should evaluate the policies of the cluster", func() {
+			clientset := k8sfake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}})
+			gcsClientset := gcsfake.NewSimpleClientset(&v1beta1.BucketAccessPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "team-a"},
+				Spec: v1beta1.BucketAccessPolicySpec{
+					Namespaces: []string{"team-a"},
+					Buckets:    []string{"team-a-*"},
+					Mode:       v1beta1.BucketAccessReadWrite,
+				},
+			})
+
+			allowed, _, err := CheckBucketAccess(context.Background(), clientset, gcsClientset, "team-a", "team-a-data", false)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(allowed).To(BeTrue())
+
+			allowed, _, err = CheckBucketAccess(context.Background(), clientset, gcsClientset, "team-a", "team-b-data", false)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(allowed).To(BeFalse())
+		})
+
+		It("should fail for unknown namespaces", func() {
+			_, _, err := CheckBucketAccess(context.Background(), k8sfake.NewSimpleClientset(), gcsfake.NewSimpleClientset(), "team-a", "team-a-data", false)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,127 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const (
+	// BucketNameMaxLength is the maximum length of bucket names without dots.
+	BucketNameMaxLength = 63
+	// bucketNameMaxLengthWithDots is the maximum length of bucket names containing dots.
+	bucketNameMaxLengthWithDots = 222
+	// bucketNameMaxBaseLength leaves room for the suffix and matches the truncation of earlier versions.
+	bucketNameMaxBaseLength = 48
+
+	DefaultBucketNameTemplate = "{{ .VolumeName }}"
+)
+
+var (
+	bucketNamePattern     = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*[a-z0-9]$`)
+	bucketNameIllegalChar = regexp.MustCompile(`[^a-z0-9._-]+`)
+	bucketNameRepeatedSep = regexp.MustCompile(`[-_.]{2,}`)
+	// https://cloud.google.com/storage/docs/buckets#naming
+	bucketNameGoogle = regexp.MustCompile(`g[o0]{2}g[l1]e`)
+)
+
+// BucketNameParams holds the values available to bucket name templates.
+type BucketNameParams struct {
+	ClusterID  string
+	Namespace  string
+	PVCName    string
+	VolumeName string
+}
+
+// BucketName returns the default name of the bucket backing the given CSI volume name.
+func BucketName(volumeId string) string {
+	return GenerateBucketName(SanitizeBucketName(volumeId), volumeId, 0)
+}
+
+// RenderBucketName renders a bucket name template, e.g. `{{ .ClusterID }}-{{ .Namespace }}-{{ .PVCName }}`,
+// and appends the suffix for the given attempt. The result is sanitized and validated against the GCS naming rules.
+func RenderBucketName(nameTemplate string, params BucketNameParams, attempt int) (string, error) {
+	tmpl, err := template.New("bucket").Option("missingkey=error").Parse(nameTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid bucket name template %q: %v", nameTemplate, err)
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, params); err != nil {
+		return "", fmt.Errorf("invalid bucket name template %q: %v", nameTemplate, err)
+	}
+
+	name := GenerateBucketName(SanitizeBucketName(rendered.String()), params.VolumeName, attempt)
+	if err := ValidateBucketName(name); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// GenerateBucketName truncates the base name so that the suffix derived from the volume name and attempt fits.
+// The suffix of the first attempt is the same CRC32 hash that was always used so existing names are stable.
+func GenerateBucketName(base string, volumeName string, attempt int) string {
+	hashInput := volumeName
+	if attempt > 0 {
+		hashInput = fmt.Sprintf("%s-%d", volumeName, attempt)
+	}
+	suffix := fmt.Sprintf("%x", crc32.ChecksumIEEE([]byte(hashInput)))
+
+	if len(base) > bucketNameMaxBaseLength {
+		base = strings.TrimRight(base[0:bucketNameMaxBaseLength], "-_.")
+	}
+	if base == "" {
+		return "b-" + suffix
+	}
+
+	return base + "-" + suffix
+}
+
+// SanitizeBucketName makes a best effort to turn an arbitrary string into a valid bucket name component.
+func SanitizeBucketName(name string) string {
+	name = strings.ToLower(name)
+	name = bucketNameIllegalChar.ReplaceAllString(name, "-")
+	name = bucketNameRepeatedSep.ReplaceAllString(name, "-")
+	name = bucketNameGoogle.ReplaceAllString(name, "gcs")
+	name = strings.Trim(name, "-_.")
+
+	if strings.HasPrefix(name, "goog") {
+		name = "b-" + name
+	}
+
+	return name
+}
+
+// ValidateBucketName checks a bucket name against the GCS naming rules.
+func ValidateBucketName(name string) error {
+	maxLength := BucketNameMaxLength
+	if strings.Contains(name, ".") {
+		maxLength = bucketNameMaxLengthWithDots
+	}
+
+	switch {
+	case len(name) < 3 || len(name) > maxLength:
+		return fmt.Errorf("bucket name %q must contain 3-%d characters", name, maxLength)
+	case !bucketNamePattern.MatchString(name):
+		return fmt.Errorf("bucket name %q must only contain lowercase letters, digits, dashes, underscores and dots, and start and end with a letter or digit", name)
+	case strings.HasPrefix(name, "goog"):
+		return fmt.Errorf("bucket name %q must not start with the reserved prefix \"goog\"", name)
+	case bucketNameGoogle.MatchString(name):
+		return fmt.Errorf("bucket name %q must not contain \"google\" or close misspellings", name)
+	case net.ParseIP(name) != nil:
+		return fmt.Errorf("bucket name %q must not be an IP address", name)
+	}
+
+	for _, component := range strings.Split(name, ".") {
+		if len(component) == 0 || len(component) > BucketNameMaxLength {
+			return fmt.Errorf("dot-separated components of bucket name %q must contain 1-%d characters", name, BucketNameMaxLength)
+		}
+	}
+
+	return nil
+}
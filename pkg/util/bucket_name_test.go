@@ -0,0 +1,68 @@
+package util_test
+
+import (
+	"strings"
+
+	. "github.com/ofek/csi-gcs/pkg/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BucketName", func() {
+	Describe("BucketName", func() {
+		It("should keep names of earlier versions", func() {
+			Expect(BucketName("pvc-906ed812-2c06-4eaa-a80e-7115e8ffd653")).To(Equal("pvc-906ed812-2c06-4eaa-a80e-7115e8ffd653-f44072b8"))
+		})
+	})
+
+	Describe("RenderBucketName", func() {
+		params := BucketNameParams{
+			ClusterID:  "Prod_Cluster",
+			Namespace:  "team-a",
+			PVCName:    "data",
+			VolumeName: "pvc-1",
+		}
+
+		It("should render the template", func() {
+			Expect(RenderBucketName("{{ .ClusterID }}-{{ .Namespace }}-{{ .PVCName }}", params, 0)).To(Equal("prod_cluster-team-a-data-" + strings.TrimPrefix(BucketName("pvc-1"), "pvc-1-")))
+		})
+
+		It("should use another suffix on retries", func() {
+			first, err := RenderBucketName(DefaultBucketNameTemplate, params, 0)
+			Expect(err).ShouldNot(HaveOccurred())
+			second, err := RenderBucketName(DefaultBucketNameTemplate, params, 1)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(first).To(Equal(BucketName("pvc-1")))
+			Expect(second).NotTo(Equal(first))
+			Expect(second).To(HavePrefix("pvc-1-"))
+		})
+
+		It("should produce valid names from arbitrary input", func() {
+			name, err := RenderBucketName("{{ .Namespace }}", BucketNameParams{Namespace: "Google.Team/ä" + strings.Repeat("x", 100)}, 0)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(name).NotTo(ContainSubstring("google"))
+			Expect(len(name)).To(BeNumerically("<=", BucketNameMaxLength))
+			Expect(ValidateBucketName(name)).To(Succeed())
+		})
+
+		It("should reject unknown fields", func() {
+			_, err := RenderBucketName("{{ .Foo }}", params, 0)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("ValidateBucketName", func() {
+		It("should accept valid names", func() {
+			for _, name := range []string{"abc", "my-bucket_1", "example.com", "a.b-c"} {
+				Expect(ValidateBucketName(name)).To(Succeed(), name)
+			}
+		})
+
+		It("should reject invalid names", func() {
+			for _, name := range []string{"ab", "My-Bucket", "-bucket", "bucket-", "goog-bucket", "my-google-bucket", "my-g00gle", "192.168.5.4", "a..b", strings.Repeat("a", 64)} {
+				Expect(ValidateBucketName(name)).NotTo(Succeed(), name)
+			}
+		})
+	})
+})
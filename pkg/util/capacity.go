@@ -0,0 +1,78 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ParseCapacity parses a quantity such as `500Gi` or `10Ti` into bytes.
+func ParseCapacity(value string) (int64, error) {
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid capacity %q: %v", value, err)
+	}
+	if quantity.Sign() < 0 {
+		return 0, fmt.Errorf("invalid capacity %q: must not be negative", value)
+	}
+
+	return quantity.Value(), nil
+}
+
+// ParseCapacityBudgets parses per-project budgets in the form `<project>=<quantity>[,<project>=<quantity>...]`.
+func ParseCapacityBudgets(value string) (map[string]int64, error) {
+	budgets := map[string]int64{}
+	if value == "" {
+		return budgets, nil
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid capacity budget %q, expected <project>=<quantity>", entry)
+		}
+
+		budget, err := ParseCapacity(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		budgets[parts[0]] = budget
+	}
+
+	return budgets, nil
+}
+
+// ProvisionedCapacity sums the capacity labels of the buckets in the project that were provisioned by the driver,
+// ignoring the bucket of the excluded volume name so that retries of CreateVolume aren't counted twice.
+func ProvisionedCapacity(ctx context.Context, client *storage.Client, projectId string, driverName string, excludedVolumeName string) (int64, error) {
+	var total int64
+
+	it := client.Buckets(ctx, projectId)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+
+		if !IsBucketOwnedByDriver(attrs, driverName) {
+			continue
+		}
+		if excludedVolumeName != "" && IsBucketOwnedByVolume(attrs, driverName, excludedVolumeName) {
+			continue
+		}
+
+		capacity, err := BucketCapacity(attrs)
+		if err != nil {
+			return 0, err
+		}
+		total += capacity
+	}
+
+	return total, nil
+}
@@ -0,0 +1,43 @@
+package util_test
+
+import (
+	. "github.com/ofek/csi-gcs/pkg/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Capacity", func() {
+	Describe("ParseCapacity", func() {
+		It("should parse quantities", func() {
+			Expect(ParseCapacity("5Gi")).To(Equal(int64(5 * 1024 * 1024 * 1024)))
+			Expect(ParseCapacity("1T")).To(Equal(int64(1000 * 1000 * 1000 * 1000)))
+		})
+
+		It("should reject invalid quantities", func() {
+			for _, value := range []string{"", "foo", "-1Gi"} {
+				_, err := ParseCapacity(value)
+				Expect(err).To(HaveOccurred(), value)
+			}
+		})
+	})
+
+	Describe("ParseCapacityBudgets", func() {
+		It("should parse budgets per project", func() {
+			Expect(ParseCapacityBudgets("project-a=1Ki, project-b=2Ki")).To(Equal(map[string]int64{
+				"project-a": 1024,
+				"project-b": 2048,
+			}))
+		})
+
+		It("should allow no budgets", func() {
+			Expect(ParseCapacityBudgets("")).To(BeEmpty())
+		})
+
+		It("should reject malformed budgets", func() {
+			for _, value := range []string{"project-a", "=1Ki", "project-a=foo"} {
+				_, err := ParseCapacityBudgets(value)
+				Expect(err).To(HaveOccurred(), value)
+			}
+		})
+	})
+})
@@ -0,0 +1,35 @@
+package util
+
+import (
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	gcs "github.com/ofek/csi-gcs/pkg/client/clientset/clientset"
+)
+
+// NewClientsets returns the clientsets of the Kubernetes API and of the driver's resources, configured by the
+// kubeconfig file or, if empty, by the service account of the pod.
+func NewClientsets(kubeconfig string) (kubernetes.Interface, gcs.Interface, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig == "" {
+		config, err = rest.InClusterConfig()
+	} else {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcsClientset, err := gcs.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return clientset, gcsClientset, nil
+}
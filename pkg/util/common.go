@@ -2,7 +2,10 @@ package util
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io/ioutil"
@@ -16,15 +19,17 @@ import (
 	"cloud.google.com/go/storage"
 	"github.com/ofek/csi-gcs/pkg/apis/published-volume/v1beta1"
 	gcs "github.com/ofek/csi-gcs/pkg/client/clientset/clientset"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/api/iterator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/klog"
+	"k8s.io/klog/v2"
 )
 
 func ParseEndpoint(endpoint string) (string, string, error) {
@@ -101,7 +106,7 @@ func GetKey(secrets map[string]string, keyStoragePath string) (string, error) {
 		}
 	}
 
-	klog.V(5).Info("Saving key contents to a temporary location")
+	klog.V(5).InfoS("Saving key contents to a temporary location", "path", keyStoragePath)
 	keyFile, err := CreateFile(keyStoragePath, keyContents)
 	if err != nil {
 		return "", status.Errorf(codes.Internal, "Unable to save secret 'key' / 'key.json' to %s", keyStoragePath)
@@ -114,21 +119,11 @@ func CleanupKey(keyFile string, keyStoragePath string) {
 	location := filepath.Dir(keyFile)
 	if location == keyStoragePath {
 		if err := os.Remove(keyFile); err != nil {
-			klog.Warningf("Error removing temporary key file %s: %s", keyFile, err)
+			klog.ErrorS(err, "Error removing temporary key file", "path", keyFile)
 		}
 	}
 }
 
-func BucketName(volumeId string) string {
-	// return volumeId
-	var crc32Hash = crc32.ChecksumIEEE([]byte(volumeId))
-
-	if len(volumeId) > 48 {
-		volumeId = volumeId[0:48]
-	}
-	return fmt.Sprintf("%s-%x", strings.ToLower(volumeId), crc32Hash)
-}
-
 func BucketCapacity(attrs *storage.BucketAttrs) (int64, error) {
 	for labelName, labelValue := range attrs.Labels {
 		if labelName != "capacity" {
@@ -151,18 +146,174 @@ func SetBucketCapacity(ctx context.Context, bucket *storage.BucketHandle, capaci
 
 	uattrs.SetLabel("capacity", strconv.FormatInt(capacity, 10))
 
-	return bucket.Update(ctx, uattrs)
+	return UpdateBucket(ctx, bucket, uattrs)
+}
+
+const (
+	BucketLabelDriver = "csi-driver"
+	BucketLabelVolume = "csi-volume"
+)
+
+// BucketLabelValue turns a string into a valid bucket label value (lowercase letters, digits, dashes and underscores).
+func BucketLabelValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		}
+		return '-'
+	}, value)
+}
+
+// VolumeNameHash returns the hash of a CSI volume name that is stored in the ownership label.
+func VolumeNameHash(volumeName string) string {
+	return strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(volumeName))), 16)
+}
+
+// BucketOwnerLabels returns the labels that mark a bucket as provisioned by the driver for the given CSI volume name.
+func BucketOwnerLabels(driverName string, volumeName string) map[string]string {
+	return map[string]string{
+		BucketLabelDriver: BucketLabelValue(driverName),
+		BucketLabelVolume: VolumeNameHash(volumeName),
+	}
+}
+
+// IsBucketOwnedByDriver checks whether the bucket was provisioned by the driver.
+func IsBucketOwnedByDriver(attrs *storage.BucketAttrs, driverName string) bool {
+	return isOwnedByDriver(attrs.Labels, driverName)
+}
+
+// IsBucketOwnedByVolume checks whether the bucket was provisioned by the driver for the given CSI volume name.
+func IsBucketOwnedByVolume(attrs *storage.BucketAttrs, driverName string, volumeName string) bool {
+	return isOwnedByVolume(attrs.Labels, driverName, volumeName)
+}
+
+// IsPrefixOwnedByDriver checks whether the prefix placeholder object was provisioned by the driver.
+func IsPrefixOwnedByDriver(attrs *storage.ObjectAttrs, driverName string) bool {
+	return isOwnedByDriver(attrs.Metadata, driverName)
+}
+
+// IsPrefixOwnedByVolume checks whether the prefix placeholder object was provisioned by the driver for the given CSI volume name.
+func IsPrefixOwnedByVolume(attrs *storage.ObjectAttrs, driverName string, volumeName string) bool {
+	return isOwnedByVolume(attrs.Metadata, driverName, volumeName)
+}
+
+func isOwnedByDriver(labels map[string]string, driverName string) bool {
+	return labels[BucketLabelDriver] == BucketLabelValue(driverName)
+}
+
+func isOwnedByVolume(labels map[string]string, driverName string, volumeName string) bool {
+	return isOwnedByDriver(labels, driverName) && labels[BucketLabelVolume] == VolumeNameHash(volumeName)
+}
+
+// PrefixObjectName returns the name of the placeholder object that represents a prefix as a directory.
+func PrefixObjectName(prefix string) string {
+	return strings.TrimSuffix(prefix, "/") + "/"
+}
+
+// GetPrefixAttrs returns the attributes of the placeholder object of a prefix.
+func GetPrefixAttrs(ctx context.Context, bucket *storage.BucketHandle, prefix string) (attrs *storage.ObjectAttrs, err error) {
+	bucket = withoutClientRetries(bucket)
+	err = RetryGCS(ctx, GCSBackoff, true, func(ctx context.Context) (err error) {
+		attrs, err = bucket.Object(PrefixObjectName(prefix)).Attrs(ctx)
+		return err
+	})
+	return attrs, err
+}
+
+// CreatePrefix creates the placeholder object of a prefix with the given metadata.
+func CreatePrefix(ctx context.Context, bucket *storage.BucketHandle, prefix string, metadata map[string]string) (attrs *storage.ObjectAttrs, err error) {
+	bucket = withoutClientRetries(bucket)
+	err = RetryGCS(ctx, GCSBackoff, false, func(ctx context.Context) error {
+		w := bucket.Object(PrefixObjectName(prefix)).If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+		w.ContentType = "application/x-directory"
+		w.Metadata = metadata
+		if err := w.Close(); err != nil {
+			return err
+		}
+
+		attrs = w.Attrs()
+		return nil
+	})
+	return attrs, err
+}
+
+func PrefixCapacity(attrs *storage.ObjectAttrs) (int64, error) {
+	labelValue, found := attrs.Metadata["capacity"]
+	if !found {
+		return 0, nil
+	}
+
+	capacity, err := strconv.ParseInt(labelValue, 10, 64)
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "Failed to parse prefix capacity: %v", labelValue)
+	}
+
+	return capacity, nil
+}
+
+func SetPrefixCapacity(ctx context.Context, bucket *storage.BucketHandle, attrs *storage.ObjectAttrs, capacity int64) (*storage.ObjectAttrs, error) {
+	metadata := map[string]string{}
+	for k, v := range attrs.Metadata {
+		metadata[k] = v
+	}
+	metadata["capacity"] = strconv.FormatInt(capacity, 10)
+
+	bucket = withoutClientRetries(bucket)
+	var updated *storage.ObjectAttrs
+	err := RetryGCS(ctx, GCSBackoff, true, func(ctx context.Context) (err error) {
+		updated, err = bucket.Object(attrs.Name).Update(ctx, storage.ObjectAttrsToUpdate{Metadata: metadata})
+		return err
+	})
+	return updated, err
+}
+
+// DeletePrefix deletes all objects under the prefix, removing the placeholder object last so that an interrupted deletion can be retried.
+func DeletePrefix(ctx context.Context, bucket *storage.BucketHandle, prefix string) error {
+	bucket = withoutClientRetries(bucket)
+	placeholder := PrefixObjectName(prefix)
+
+	// Objects that are already deleted aren't listed again, so the deletion can be retried as a whole
+	return RetryGCS(ctx, GCSBackoff, true, func(ctx context.Context) error {
+		it := bucket.Objects(ctx, &storage.Query{Prefix: placeholder})
+		for {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				break
+			} else if err != nil {
+				return err
+			}
+
+			if attrs.Name == placeholder {
+				continue
+			}
+			if err := bucket.Object(attrs.Name).Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+				return err
+			}
+		}
+
+		if err := bucket.Object(placeholder).Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			return err
+		}
+
+		return nil
+	})
 }
 
 func BucketExists(ctx context.Context, bucket *storage.BucketHandle) (exists bool, err error) {
-	query := &storage.Query{Prefix: ""}
+	bucket = withoutClientRetries(bucket)
 
-	it := bucket.Objects(ctx, query)
-	_, err = it.Next()
+	err = RetryGCS(ctx, GCSBackoff, true, func(ctx context.Context) error {
+		_, err := bucket.Objects(ctx, &storage.Query{Prefix: ""}).Next()
+		if err == iterator.Done {
+			return nil
+		}
+		return err
+	})
 
-	if err == iterator.Done {
-		return true, nil
-	} else if err != nil && err.Error() == "storage: bucket doesn't exist" {
+	if errors.Is(err, storage.ErrBucketNotExist) {
 		return false, nil
 	} else if err != nil {
 		return false, err
@@ -171,23 +322,39 @@ func BucketExists(ctx context.Context, bucket *storage.BucketHandle) (exists boo
 	return true, nil
 }
 
-func GetPvcAnnotations(ctx context.Context, pvcName string, pvcNamespace string) (annotations map[string]string, err error) {
-	config, err := rest.InClusterConfig()
+func GetPvcAnnotations(ctx context.Context, clientset kubernetes.Interface, pvcName string, pvcNamespace string) (annotations map[string]string, err error) {
+	ctx, span := StartSpan(ctx, "GetPvcAnnotations", attribute.String("pvc", pvcNamespace+"/"+pvcName))
+	defer func() { EndSpan(span, err) }()
+
+	pvc, err := GetPvc(ctx, clientset, pvcName, pvcNamespace)
 	if err != nil {
 		return nil, err
 	}
-	// creates the clientset
-	clientset, err := kubernetes.NewForConfig(config)
+
+	return pvc.ObjectMeta.Annotations, nil
+}
+
+func GetPvc(ctx context.Context, clientset kubernetes.Interface, pvcName string, pvcNamespace string) (pvc *corev1.PersistentVolumeClaim, err error) {
+	return clientset.CoreV1().PersistentVolumeClaims(pvcNamespace).Get(ctx, pvcName, metav1.GetOptions{})
+}
+
+// GetNodeTopology returns the region and zone of the node from its well-known topology labels.
+func GetNodeTopology(ctx context.Context, clientset kubernetes.Interface, nodeName string) (region string, zone string, err error) {
+	node, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
 
-	pvc, err := clientset.CoreV1().PersistentVolumeClaims(pvcNamespace).Get(ctx, pvcName, metav1.GetOptions{})
-	if err != nil {
-		return nil, err
+	region = node.Labels[corev1.LabelTopologyRegion]
+	if region == "" {
+		region = node.Labels[corev1.LabelFailureDomainBetaRegion]
+	}
+	zone = node.Labels[corev1.LabelTopologyZone]
+	if zone == "" {
+		zone = node.Labels[corev1.LabelFailureDomainBetaZone]
 	}
 
-	return pvc.ObjectMeta.Annotations, nil
+	return region, zone, nil
 }
 
 // DriverReadyLabel returns the driver-ready label according to the driver name.
@@ -201,16 +368,7 @@ func DriverReadyLabelJSONPatchEscaped(driverName string) string {
 }
 
 // SetDriverReadyLabel set the label <driver name>/driver-ready=<isReady> on the given node.
-func SetDriverReadyLabel(ctx context.Context, driverName string, nodeName string, isReady bool) (err error) {
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		return err
-	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return err
-	}
-
+func SetDriverReadyLabel(ctx context.Context, clientset kubernetes.Interface, driverName string, nodeName string, isReady bool) (err error) {
 	patch := []struct {
 		Op    string `json:"op"`
 		Path  string `json:"path"`
@@ -232,32 +390,15 @@ func SetDriverReadyLabel(ctx context.Context, driverName string, nodeName string
 	return nil
 }
 
-func DeletePod(ctx context.Context, namespace string, name string) (err error) {
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		return err
-	}
-	// creates the clientset
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return err
-	}
+func GetPod(ctx context.Context, clientset kubernetes.Interface, namespace string, name string) (pod *corev1.Pod, err error) {
+	return clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+}
 
+func DeletePod(ctx context.Context, clientset kubernetes.Interface, namespace string, name string) (err error) {
 	return clientset.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{})
 }
 
-func GetRegisteredMounts(ctx context.Context, node string) (list *v1beta1.PublishedVolumeList, err error) {
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		return nil, err
-	}
-
-	// creates the clientset
-	clientset, err := gcs.NewForConfig(config)
-	if err != nil {
-		return nil, err
-	}
-
+func GetRegisteredMounts(ctx context.Context, clientset gcs.Interface, node string) (list *v1beta1.PublishedVolumeList, err error) {
 	return clientset.GcsV1beta1().PublishedVolumes().List(ctx, metav1.ListOptions{
 		LabelSelector: labels.Set(map[string]string{
 			"gcs.csi.ofek.dev/node": node,
@@ -265,34 +406,121 @@ func GetRegisteredMounts(ctx context.Context, node string) (list *v1beta1.Publis
 	})
 }
 
-func RegisterMount(ctx context.Context, volumeID string, targetPath string, node string, podNamespace string, podName string, options map[string]string) (err error) {
-	config, err := rest.InClusterConfig()
+// VolumeClaimedError is returned when a single writer volume is already published by another writer.
+type VolumeClaimedError struct {
+	VolumeID   string
+	Node       string
+	TargetPath string
+}
+
+func (e *VolumeClaimedError) Error() string {
+	return fmt.Sprintf("Volume %s only allows a single writer and is already published at %s on node %s", e.VolumeID, e.TargetPath, e.Node)
+}
+
+// mountRecordName returns the name of the PublishedVolume of a mount.
+func mountRecordName(volumeID string, targetPath string, node string) string {
+	return strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s-%s-%s", volumeID, targetPath, node)))), 16)
+}
+
+// writerClaimName returns the name of the PublishedVolume of the single writer of a volume, which is the same on
+// every node so that only one of them can create it.
+func writerClaimName(volumeID string) string {
+	hash := sha256.Sum256([]byte(volumeID))
+	return "writer-" + hex.EncodeToString(hash[:])
+}
+
+func RegisterMount(ctx context.Context, clientset kubernetes.Interface, gcsClientset gcs.Interface, volumeID string, targetPath string, node string, podNamespace string, podName string, podUID string, readOnly bool, options map[string]string, sources map[string]string) (err error) {
+	ctx, span := StartSpan(ctx, "RegisterMount", attribute.String("volume", volumeID), attribute.String("node", node))
+	defer func() { EndSpan(span, err) }()
+
+	publishedVolume, err := newPublishedVolume(ctx, clientset, mountRecordName(volumeID, targetPath, node), volumeID, targetPath, node, podNamespace, podName, podUID, readOnly, options, sources)
 	if err != nil {
 		return err
 	}
 
-	// creates the clientsets
-	clientset, err := gcs.NewForConfig(config)
+	_, err = gcsClientset.GcsV1beta1().PublishedVolumes().Create(ctx, publishedVolume, metav1.CreateOptions{})
+	return err
+}
+
+// ClaimVolumeWriter registers the mount as the single writer of the volume, failing with a VolumeClaimedError if
+// another mount is registered as its writer. Claims are atomic across nodes, and claiming again for the same mount
+// succeeds. Stale claims, whose pod is gone, are taken over. The claim also serves as the record of the mount, see
+// GetRegisteredMounts.
+func ClaimVolumeWriter(ctx context.Context, clientset kubernetes.Interface, gcsClientset gcs.Interface, volumeID string, targetPath string, node string, podNamespace string, podName string, podUID string, options map[string]string, sources map[string]string) (err error) {
+	ctx, span := StartSpan(ctx, "ClaimVolumeWriter", attribute.String("volume", volumeID), attribute.String("node", node))
+	defer func() { EndSpan(span, err) }()
+
+	name := writerClaimName(volumeID)
+	publishedVolume, err := newPublishedVolume(ctx, clientset, name, volumeID, targetPath, node, podNamespace, podName, podUID, false, options, sources)
 	if err != nil {
 		return err
 	}
-	coreClientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
+
+	_, err = gcsClientset.GcsV1beta1().PublishedVolumes().Create(ctx, publishedVolume, metav1.CreateOptions{})
+	if !apierrors.IsAlreadyExists(err) {
 		return err
 	}
 
-	name := strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s-%s-%s", volumeID, targetPath, node)))), 16)
+	for {
+		existing, err := gcsClientset.GcsV1beta1().PublishedVolumes().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if existing.Spec.Node == node && existing.Spec.TargetPath == targetPath {
+			return nil
+		}
 
-	nodeResource, err := coreClientset.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+		stale, err := isStaleClaim(ctx, clientset, existing)
+		if err != nil {
+			return err
+		}
+		if !stale {
+			return &VolumeClaimedError{VolumeID: volumeID, Node: existing.Spec.Node, TargetPath: existing.Spec.TargetPath}
+		}
+
+		// Only take over the claim as it was checked, another mount may be doing the same
+		klog.FromContext(ctx).Info("Taking over stale single writer claim", "volume", volumeID, "claimNode", existing.Spec.Node, "claimPod", existing.Spec.Pod.Namespace+"/"+existing.Spec.Pod.Name)
+		publishedVolume.ResourceVersion = existing.ResourceVersion
+		_, err = gcsClientset.GcsV1beta1().PublishedVolumes().Update(ctx, publishedVolume, metav1.UpdateOptions{})
+		if !apierrors.IsConflict(err) {
+			return err
+		}
+	}
+}
+
+// isStaleClaim reports whether the pod of a single writer claim is gone, i.e. it was deleted, replaced by a pod of the
+// same name or moved to another node, such as when its node was lost before the volume was unpublished. Claims of
+// unknown pods, without podInfoOnMount, are never stale.
+func isStaleClaim(ctx context.Context, clientset kubernetes.Interface, claim *v1beta1.PublishedVolume) (bool, error) {
+	if claim.Spec.Pod.Namespace == "" || claim.Spec.Pod.Name == "" {
+		return false, nil
+	}
+
+	pod, err := clientset.CoreV1().Pods(claim.Spec.Pod.Namespace).Get(ctx, claim.Spec.Pod.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if claim.Spec.Pod.UID != "" && pod.UID != types.UID(claim.Spec.Pod.UID) {
+		return true, nil
+	}
+	return pod.Spec.NodeName != claim.Spec.Node, nil
+}
+
+func newPublishedVolume(ctx context.Context, clientset kubernetes.Interface, name string, volumeID string, targetPath string, node string, podNamespace string, podName string, podUID string, readOnly bool, options map[string]string, sources map[string]string) (*v1beta1.PublishedVolume, error) {
+	nodeResource, err := clientset.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = clientset.GcsV1beta1().PublishedVolumes().Create(ctx, &v1beta1.PublishedVolume{
+	return &v1beta1.PublishedVolume{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: name,
 			Labels: map[string]string{
-				"gcs.csi.ofek.dev/node": node,
+				"gcs.csi.ofek.dev/node":   node,
+				"gcs.csi.ofek.dev/volume": VolumeNameHash(volumeID),
 			},
 			OwnerReferences: []metav1.OwnerReference{
 				{
@@ -308,38 +536,44 @@ func RegisterMount(ctx context.Context, volumeID string, targetPath string, node
 			TargetPath:   targetPath,
 			VolumeHandle: volumeID,
 			Options:      options,
+			Sources:      sources,
+			ReadOnly:     readOnly,
 			Pod: v1beta1.PublishedVolumeSpecPod{
 				Namespace: podNamespace,
 				Name:      podName,
+				UID:       podUID,
 			},
 		},
-	}, metav1.CreateOptions{})
-	if err != nil {
-		return err
-	}
-
-	return nil
+	}, nil
 }
 
-func UnregisterMount(ctx context.Context, volumeID string, targetPath string, node string) (err error) {
-	config, err := rest.InClusterConfig()
-	if err != nil {
+// UnregisterMount removes the record of the mount, and its claim of the volume if it is the single writer.
+func UnregisterMount(ctx context.Context, clientset gcs.Interface, volumeID string, targetPath string, node string) (err error) {
+	delPropPolicy := metav1.DeletePropagationForeground
+	err = clientset.GcsV1beta1().PublishedVolumes().Delete(ctx, mountRecordName(volumeID, targetPath, node), metav1.DeleteOptions{
+		PropagationPolicy: &delPropPolicy,
+	})
+	// Mounts published before the driver registered them, or whose registration failed, have no record
+	if err != nil && !apierrors.IsNotFound(err) {
 		return err
 	}
 
-	// creates the clientset
-	clientset, err := gcs.NewForConfig(config)
-	if err != nil {
+	claim, err := clientset.GcsV1beta1().PublishedVolumes().Get(ctx, writerClaimName(volumeID), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
 		return err
 	}
+	if claim.Spec.Node != node || claim.Spec.TargetPath != targetPath {
+		return nil
+	}
 
-	name := strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s-%s-%s", volumeID, targetPath, node)))), 16)
-
-	delPropPolicy := metav1.DeletePropagationForeground
-	err = clientset.GcsV1beta1().PublishedVolumes().Delete(ctx, name, metav1.DeleteOptions{
+	// The claim may have been released and claimed by another writer since
+	err = clientset.GcsV1beta1().PublishedVolumes().Delete(ctx, claim.Name, metav1.DeleteOptions{
 		PropagationPolicy: &delPropPolicy,
+		Preconditions:     metav1.NewUIDPreconditions(string(claim.UID)),
 	})
-	if err != nil {
+	if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
 		return err
 	}
 
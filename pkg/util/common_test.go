@@ -1,12 +1,23 @@
 package util_test
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 
+	"cloud.google.com/go/storage"
+	"github.com/ofek/csi-gcs/pkg/apis/published-volume/v1beta1"
+	gcsfake "github.com/ofek/csi-gcs/pkg/client/clientset/clientset/fake"
 	. "github.com/ofek/csi-gcs/pkg/util"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 var _ = Describe("Common", func() {
@@ -55,4 +66,219 @@ var _ = Describe("Common", func() {
 			Expect(ioutil.ReadAll(f)).To(BeEquivalentTo("Content of key.json"))
 		})
 	})
+
+	Describe("BucketOwnerLabels", func() {
+		It("should produce valid label values", func() {
+			Expect(BucketOwnerLabels("gcs.csi.ofek.dev", "pvc-1")).To(Equal(map[string]string{
+				"csi-driver": "gcs-csi-ofek-dev",
+				"csi-volume": VolumeNameHash("pvc-1"),
+			}))
+		})
+
+		It("should match buckets of the same volume only", func() {
+			attrs := &storage.BucketAttrs{Labels: BucketOwnerLabels("gcs.csi.ofek.dev", "pvc-1")}
+
+			Expect(IsBucketOwnedByDriver(attrs, "gcs.csi.ofek.dev")).To(BeTrue())
+			Expect(IsBucketOwnedByVolume(attrs, "gcs.csi.ofek.dev", "pvc-1")).To(BeTrue())
+			Expect(IsBucketOwnedByVolume(attrs, "gcs.csi.ofek.dev", "pvc-2")).To(BeFalse())
+			Expect(IsBucketOwnedByDriver(attrs, "other.csi.example.com")).To(BeFalse())
+		})
+
+		It("should not match unlabeled buckets", func() {
+			attrs := &storage.BucketAttrs{Labels: map[string]string{"capacity": "1024"}}
+
+			Expect(IsBucketOwnedByDriver(attrs, "gcs.csi.ofek.dev")).To(BeFalse())
+		})
+	})
+
+	Describe("GetPvcAnnotations", func() {
+		It("should return the annotations of the claim", func() {
+			clientset := k8sfake.NewSimpleClientset(&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+				Name:        "data",
+				Namespace:   "default",
+				Annotations: map[string]string{"gcs.csi.ofek.dev/dir-mode": "0700"},
+			}})
+
+			Expect(GetPvcAnnotations(context.Background(), clientset, "data", "default")).To(Equal(map[string]string{
+				"gcs.csi.ofek.dev/dir-mode": "0700",
+			}))
+		})
+	})
+
+	Describe("GetNodeTopology", func() {
+		It("should prefer the stable labels", func() {
+			clientset := k8sfake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name: "node",
+				Labels: map[string]string{
+					corev1.LabelTopologyRegion:          "europe-west1",
+					corev1.LabelFailureDomainBetaRegion: "us-central1",
+					corev1.LabelFailureDomainBetaZone:   "us-central1-a",
+				},
+			}})
+
+			region, zone, err := GetNodeTopology(context.Background(), clientset, "node")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(region).To(Equal("europe-west1"))
+			Expect(zone).To(Equal("us-central1-a"))
+		})
+	})
+
+	Describe("SetDriverReadyLabel", func() {
+		It("should label the node", func() {
+			clientset := k8sfake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:   "node",
+				Labels: map[string]string{"kubernetes.io/hostname": "node"},
+			}})
+
+			Expect(SetDriverReadyLabel(context.Background(), clientset, "gcs.csi.ofek.dev", "node", true)).To(Succeed())
+
+			node, err := clientset.CoreV1().Nodes().Get(context.Background(), "node", metav1.GetOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(node.Labels).To(HaveKeyWithValue("gcs.csi.ofek.dev/driver-ready", "true"))
+		})
+	})
+
+	Describe("Mount registration", func() {
+		var (
+			clientset    *k8sfake.Clientset
+			gcsClientset *gcsfake.Clientset
+		)
+
+		BeforeEach(func() {
+			clientset = k8sfake.NewSimpleClientset(
+				&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", UID: "1"}},
+				&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2", UID: "2"}},
+				&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a", UID: "a"}, Spec: corev1.PodSpec{NodeName: "node-1"}},
+				&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "b", UID: "b"}, Spec: corev1.PodSpec{NodeName: "node-2"}},
+			)
+			gcsClientset = gcsfake.NewSimpleClientset()
+		})
+
+		register := func(node string, targetPath string, readOnly bool) {
+			Expect(RegisterMount(context.Background(), clientset, gcsClientset, "v1/_/bucket", targetPath, node, "default", "app", "", readOnly, nil, nil)).To(Succeed())
+		}
+
+		It("should list the mounts of the node", func() {
+			register("node-1", "/pods/a/mount", false)
+			register("node-2", "/pods/b/mount", false)
+
+			list, err := GetRegisteredMounts(context.Background(), gcsClientset, "node-1")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(list.Items).To(HaveLen(1))
+			Expect(list.Items[0].Spec.TargetPath).To(Equal("/pods/a/mount"))
+			Expect(list.Items[0].OwnerReferences[0].UID).To(BeEquivalentTo("1"))
+		})
+
+		// claim claims the volume for the pod of the same name as the directory of the target path
+		claim := func(node string, targetPath string) error {
+			pod := filepath.Base(filepath.Dir(targetPath))
+			return ClaimVolumeWriter(context.Background(), clientset, gcsClientset, "v1/_/bucket", targetPath, node, "default", pod, pod, nil, nil)
+		}
+		claimNode := func() string {
+			list, err := gcsClientset.GcsV1beta1().PublishedVolumes().List(context.Background(), metav1.ListOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(list.Items).To(HaveLen(1))
+			return list.Items[0].Spec.Node
+		}
+
+		It("should only let one mount claim the volume for writing", func() {
+			Expect(claim("node-1", "/pods/a/mount")).To(Succeed())
+			Expect(claim("node-1", "/pods/a/mount")).To(Succeed())
+			Expect(claim("node-2", "/pods/b/mount")).To(MatchError(&VolumeClaimedError{
+				VolumeID:   "v1/_/bucket",
+				Node:       "node-1",
+				TargetPath: "/pods/a/mount",
+			}))
+			Expect(claim("node-1", "/pods/b/mount")).To(HaveOccurred())
+
+			list, err := GetRegisteredMounts(context.Background(), gcsClientset, "node-1")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(list.Items).To(HaveLen(1))
+			Expect(list.Items[0].Spec.Pod.UID).To(Equal("a"))
+
+			// Only the writer releases the claim
+			Expect(UnregisterMount(context.Background(), gcsClientset, "v1/_/bucket", "/pods/b/mount", "node-2")).To(Succeed())
+			Expect(claim("node-2", "/pods/b/mount")).To(HaveOccurred())
+			Expect(UnregisterMount(context.Background(), gcsClientset, "v1/_/bucket", "/pods/a/mount", "node-1")).To(Succeed())
+			Expect(claim("node-2", "/pods/b/mount")).To(Succeed())
+		})
+
+		It("should take over claims of pods that are gone", func() {
+			Expect(claim("node-1", "/pods/a/mount")).To(Succeed())
+			Expect(clientset.CoreV1().Pods("default").Delete(context.Background(), "a", metav1.DeleteOptions{})).To(Succeed())
+
+			Expect(claim("node-2", "/pods/b/mount")).To(Succeed())
+			Expect(claimNode()).To(Equal("node-2"))
+		})
+
+		It("should take over claims of pods that were replaced", func() {
+			Expect(claim("node-1", "/pods/a/mount")).To(Succeed())
+			Expect(clientset.CoreV1().Pods("default").Delete(context.Background(), "a", metav1.DeleteOptions{})).To(Succeed())
+			_, err := clientset.CoreV1().Pods("default").Create(context.Background(), &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a", UID: "a2"},
+				Spec:       corev1.PodSpec{NodeName: "node-1"},
+			}, metav1.CreateOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(claim("node-2", "/pods/b/mount")).To(Succeed())
+			Expect(claimNode()).To(Equal("node-2"))
+		})
+
+		It("should take over claims of pods on other nodes", func() {
+			Expect(claim("node-2", "/pods/a/mount")).To(Succeed())
+
+			Expect(claim("node-2", "/pods/b/mount")).To(Succeed())
+			Expect(claimNode()).To(Equal("node-2"))
+		})
+
+		It("should only take over stale claims that are unchanged", func() {
+			Expect(claim("node-1", "/pods/a/mount")).To(Succeed())
+			Expect(clientset.CoreV1().Pods("default").Delete(context.Background(), "a", metav1.DeleteOptions{})).To(Succeed())
+
+			resource := v1beta1.SchemeGroupVersion.WithResource("publishedvolumes")
+			list, err := gcsClientset.GcsV1beta1().PublishedVolumes().List(context.Background(), metav1.ListOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			stale := list.Items[0]
+			stale.ResourceVersion = "7"
+			Expect(gcsClientset.Tracker().Update(resource, &stale, "")).To(Succeed())
+
+			// Another mount takes over the claim first
+			var resourceVersion string
+			gcsClientset.PrependReactor("update", "publishedvolumes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				resourceVersion = action.(k8stesting.UpdateAction).GetObject().(*v1beta1.PublishedVolume).ResourceVersion
+
+				taken := stale.DeepCopy()
+				taken.ResourceVersion = "8"
+				taken.Spec.Node = "node-2"
+				taken.Spec.TargetPath = "/pods/b/mount"
+				taken.Spec.Pod = v1beta1.PublishedVolumeSpecPod{Namespace: "default", Name: "b", UID: "b"}
+				Expect(gcsClientset.Tracker().Update(resource, taken, "")).To(Succeed())
+
+				return true, nil, apierrors.NewConflict(resource.GroupResource(), stale.Name, nil)
+			})
+
+			Expect(claim("node-1", "/pods/c/mount")).To(MatchError(&VolumeClaimedError{
+				VolumeID:   "v1/_/bucket",
+				Node:       "node-2",
+				TargetPath: "/pods/b/mount",
+			}))
+			Expect(resourceVersion).To(Equal("7"))
+		})
+
+		It("should unregister mounts", func() {
+			register("node-1", "/pods/a/mount", false)
+
+			Expect(UnregisterMount(context.Background(), gcsClientset, "v1/_/bucket", "/pods/a/mount", "node-1")).To(Succeed())
+			Expect(UnregisterMount(context.Background(), gcsClientset, "v1/_/bucket", "/pods/a/mount", "node-1")).To(Succeed())
+
+			list, err := GetRegisteredMounts(context.Background(), gcsClientset, "node-1")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(list.Items).To(BeEmpty())
+		})
+
+		It("should fail for unknown nodes", func() {
+			err := RegisterMount(context.Background(), clientset, gcsClientset, "v1/_/bucket", "/pods/a/mount", "node-3", "default", "app", "", false, nil, nil)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+	})
 })
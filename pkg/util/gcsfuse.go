@@ -0,0 +1,74 @@
+package util
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+var gcsfuseVersionPattern = regexp.MustCompile(`gcsfuse version v?(\d+)\.(\d+)`)
+
+// GcsfuseVersion returns the major and minor version of the installed gcsfuse.
+func GcsfuseVersion(ctx context.Context) (major int, minor int, err error) {
+	output, err := exec.CommandContext(ctx, "gcsfuse", "--version").CombinedOutput()
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to run gcsfuse --version: %v, output: %s", err, output)
+	}
+
+	return ParseGcsfuseVersion(string(output))
+}
+
+// ParseGcsfuseVersion parses the output of gcsfuse --version.
+func ParseGcsfuseVersion(output string) (major int, minor int, err error) {
+	match := gcsfuseVersionPattern.FindStringSubmatch(output)
+	if match == nil {
+		return 0, 0, fmt.Errorf("unknown gcsfuse version: %q", output)
+	}
+
+	major, _ = strconv.Atoi(match[1])
+	minor, _ = strconv.Atoi(match[2])
+
+	return major, minor, nil
+}
+
+// GcsfuseConfigFile returns the path of the gcsfuse config file of the mount at the target path.
+func GcsfuseConfigFile(configStoragePath string, targetPath string) string {
+	return filepath.Join(configStoragePath, targetPathHash(targetPath)+".yaml")
+}
+
+// targetPathHash names the files of a mount, target paths are too long and nested to be used directly.
+func targetPathHash(targetPath string) string {
+	hash := sha256.Sum256([]byte(targetPath))
+	return hex.EncodeToString(hash[:])
+}
+
+// WriteGcsfuseConfig saves the gcsfuse config file of the mount at the target path.
+func WriteGcsfuseConfig(configStoragePath string, targetPath string, config []byte) (string, error) {
+	if err := os.MkdirAll(configStoragePath, 0700); err != nil {
+		return "", err
+	}
+
+	configFile := GcsfuseConfigFile(configStoragePath, targetPath)
+	if err := ioutil.WriteFile(configFile, config, 0600); err != nil {
+		return "", err
+	}
+
+	return configFile, nil
+}
+
+// RemoveGcsfuseConfig removes the gcsfuse config file of the mount at the target path, if any.
+func RemoveGcsfuseConfig(configStoragePath string, targetPath string) error {
+	err := os.Remove(GcsfuseConfigFile(configStoragePath, targetPath))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
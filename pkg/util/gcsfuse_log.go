@@ -0,0 +1,159 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// GcsfuseLogFile returns the path of the gcsfuse log file of the mount at the target path.
+func GcsfuseLogFile(logStoragePath string, targetPath string) string {
+	return filepath.Join(logStoragePath, targetPathHash(targetPath)+".log")
+}
+
+// RotateLog moves the content of the log file to a single backup once it exceeds the maximum size. The file is
+// copied and truncated rather than renamed since gcsfuse keeps it open in append mode.
+func RotateLog(logFile string, maxSize int64) (rotated bool, err error) {
+	info, err := os.Stat(logFile)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil || info.Size() <= maxSize {
+		return false, err
+	}
+
+	source, err := os.Open(logFile)
+	if err != nil {
+		return false, err
+	}
+	defer source.Close()
+
+	backup, err := os.OpenFile(logFile+".1", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(backup, source); err != nil {
+		backup.Close()
+		return false, err
+	}
+	if err := backup.Close(); err != nil {
+		return false, err
+	}
+
+	return true, os.Truncate(logFile, 0)
+}
+
+// TailLog returns at most the last lines of the log file, or an empty string if there is none.
+func TailLog(logFile string, lines int) (string, error) {
+	const maxTail = 64 * 1024
+
+	file, err := os.Open(logFile)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	offset := info.Size() - maxTail
+	if offset < 0 {
+		offset = 0
+	}
+
+	content := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(content, offset); err != nil && err != io.EOF {
+		return "", err
+	}
+
+	result := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if len(result) > lines {
+		result = result[len(result)-lines:]
+	}
+
+	return strings.Join(result, "\n"), nil
+}
+
+// FollowLog calls the handler for every line appended to the log file until the context is done, rotating the
+// file whenever it exceeds the maximum size.
+func FollowLog(ctx context.Context, logFile string, maxSize int64, interval time.Duration, handler func(line string)) {
+	var offset int64
+	var partial []byte
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		content, end, err := readFrom(logFile, offset)
+		if err != nil {
+			continue
+		}
+		offset = end
+
+		partial = append(partial, content...)
+		for {
+			end := bytes.IndexByte(partial, '\n')
+			if end < 0 {
+				break
+			}
+			handler(string(partial[:end]))
+			partial = partial[end+1:]
+		}
+
+		if rotated, _ := RotateLog(logFile, maxSize); rotated {
+			offset = 0
+		}
+	}
+}
+
+// readFrom returns the content of the file after the offset and the offset of its end.
+func readFrom(logFile string, offset int64) ([]byte, int64, error) {
+	file, err := os.Open(logFile)
+	if err != nil {
+		return nil, offset, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, offset, err
+	}
+	// The file was truncated by someone else
+	if info.Size() < offset {
+		offset = 0
+	}
+
+	content := make([]byte, info.Size()-offset)
+	n, err := file.ReadAt(content, offset)
+	if err != nil && err != io.EOF {
+		return nil, offset, err
+	}
+
+	return content[:n], offset + int64(n), nil
+}
+
+// RemoveGcsfuseLog removes the gcsfuse log file of the mount at the target path and its backup, if any.
+func RemoveGcsfuseLog(logStoragePath string, targetPath string) error {
+	logFile := GcsfuseLogFile(logStoragePath, targetPath)
+	for _, file := range []string{logFile, logFile + ".1"} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
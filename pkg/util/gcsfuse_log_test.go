@@ -0,0 +1,94 @@
+package util_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/ofek/csi-gcs/pkg/util"
+)
+
+var _ = Describe("GcsfuseLog", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "logs")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("RotateLog", func() {
+		It("Should only rotate logs exceeding the maximum size", func() {
+			logFile := util.GcsfuseLogFile(dir, "/target")
+			Expect(util.RotateLog(logFile, 10)).To(BeFalse())
+
+			Expect(ioutil.WriteFile(logFile, []byte("0123456789"), 0600)).To(Succeed())
+			Expect(util.RotateLog(logFile, 10)).To(BeFalse())
+
+			Expect(ioutil.WriteFile(logFile, []byte("0123456789a"), 0600)).To(Succeed())
+			Expect(util.RotateLog(logFile, 10)).To(BeTrue())
+			Expect(ioutil.ReadFile(logFile)).To(BeEmpty())
+			Expect(ioutil.ReadFile(logFile + ".1")).To(Equal([]byte("0123456789a")))
+
+			Expect(util.RemoveGcsfuseLog(dir, "/target")).To(Succeed())
+			Expect(logFile).NotTo(BeAnExistingFile())
+			Expect(logFile + ".1").NotTo(BeAnExistingFile())
+		})
+	})
+
+	Describe("TailLog", func() {
+		It("Should return the last lines", func() {
+			logFile := filepath.Join(dir, "gcsfuse.log")
+			Expect(util.TailLog(logFile, 2)).To(BeEmpty())
+
+			Expect(ioutil.WriteFile(logFile, []byte("one\ntwo\nthree\n"), 0600)).To(Succeed())
+			Expect(util.TailLog(logFile, 2)).To(Equal("two\nthree"))
+			Expect(util.TailLog(logFile, 5)).To(Equal("one\ntwo\nthree"))
+		})
+	})
+
+	Describe("FollowLog", func() {
+		It("Should forward complete lines across rotations", func() {
+			logFile := filepath.Join(dir, "gcsfuse.log")
+			file, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+			Expect(err).ShouldNot(HaveOccurred())
+			defer file.Close()
+
+			var mutex sync.Mutex
+			var lines []string
+			followed := func() []string {
+				mutex.Lock()
+				defer mutex.Unlock()
+				return append([]string{}, lines...)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go util.FollowLog(ctx, logFile, 16, 10*time.Millisecond, func(line string) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				lines = append(lines, line)
+			})
+
+			_, err = file.WriteString("mounted bucket\npart")
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(followed).Should(Equal([]string{"mounted bucket"}))
+			Eventually(func() ([]byte, error) { return ioutil.ReadFile(logFile + ".1") }).ShouldNot(BeEmpty())
+
+			_, err = file.WriteString("ial\n" + strings.Repeat("x", 3) + "\n")
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(followed).Should(Equal([]string{"mounted bucket", "partial", "xxx"}))
+		})
+	})
+})
@@ -0,0 +1,52 @@
+package util_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/ofek/csi-gcs/pkg/util"
+)
+
+var _ = Describe("Gcsfuse", func() {
+	Describe("ParseGcsfuseVersion", func() {
+		It("Should parse release versions", func() {
+			major, minor, err := util.ParseGcsfuseVersion("gcsfuse version 2.4.0 (Go version go1.22.4)")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect([]int{major, minor}).To(Equal([]int{2, 4}))
+
+			major, minor, err = util.ParseGcsfuseVersion("gcsfuse version 0.41.12 (Go version go1.18.2)\n")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect([]int{major, minor}).To(Equal([]int{0, 41}))
+		})
+
+		It("Should reject unknown output", func() {
+			_, _, err := util.ParseGcsfuseVersion("gcsfuse: command not found")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("WriteGcsfuseConfig", func() {
+		It("Should key the config by target path", func() {
+			dir, err := ioutil.TempDir("", "configs")
+			Expect(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			storage := filepath.Join(dir, "configs")
+			target := "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv/mount"
+
+			configFile, err := util.WriteGcsfuseConfig(storage, target, []byte("implicit-dirs: true\n"))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(configFile).To(Equal(util.GcsfuseConfigFile(storage, target)))
+			Expect(configFile).NotTo(Equal(util.GcsfuseConfigFile(storage, target+"2")))
+			Expect(ioutil.ReadFile(configFile)).To(Equal([]byte("implicit-dirs: true\n")))
+
+			Expect(util.RemoveGcsfuseConfig(storage, target)).To(Succeed())
+			Expect(configFile).NotTo(BeAnExistingFile())
+			Expect(util.RemoveGcsfuseConfig(storage, target)).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,45 @@
+package util
+
+import (
+	"sync"
+)
+
+// VolumeLocks tracks the volumes and target paths with an operation in flight, so that concurrent operations on
+// the same one are refused instead of racing.
+type VolumeLocks struct {
+	mutex sync.Mutex
+	locks map[string]bool
+}
+
+func NewVolumeLocks() *VolumeLocks {
+	return &VolumeLocks{
+		locks: map[string]bool{},
+	}
+}
+
+// TryAcquire locks all of the keys, or none of them if any is already locked.
+func (l *VolumeLocks) TryAcquire(keys ...string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, key := range keys {
+		if l.locks[key] {
+			return false
+		}
+	}
+	for _, key := range keys {
+		l.locks[key] = true
+	}
+
+	return true
+}
+
+// Release unlocks the keys.
+func (l *VolumeLocks) Release(keys ...string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, key := range keys {
+		delete(l.locks, key)
+	}
+}
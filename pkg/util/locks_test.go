@@ -0,0 +1,48 @@
+package util_test
+
+import (
+	"sync"
+	"sync/atomic"
+
+	. "github.com/ofek/csi-gcs/pkg/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Locks", func() {
+	Describe("VolumeLocks", func() {
+		It("should lock all keys or none", func() {
+			locks := NewVolumeLocks()
+			Expect(locks.TryAcquire("volume", "/target")).To(BeTrue())
+			Expect(locks.TryAcquire("other", "/target")).To(BeFalse())
+			Expect(locks.TryAcquire("other")).To(BeTrue())
+
+			locks.Release("volume", "/target")
+			Expect(locks.TryAcquire("volume", "/target")).To(BeTrue())
+		})
+
+		It("should only let one of parallel operations acquire a key", func() {
+			locks := NewVolumeLocks()
+
+			var acquired int32
+			var wg sync.WaitGroup
+			start := make(chan struct{})
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					<-start
+					if locks.TryAcquire("volume") {
+						atomic.AddInt32(&acquired, 1)
+					}
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			Expect(acquired).To(Equal(int32(1)))
+		})
+	})
+})
@@ -0,0 +1,33 @@
+package util
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-logr/logr/funcr"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// LoggingFormatText is the default format of klog
+	LoggingFormatText = "text"
+	// LoggingFormatJSON writes every line as a JSON object with the message and its keys and values
+	LoggingFormatJSON = "json"
+)
+
+// SetLoggingFormat sets the format of all lines logged through klog. The verbosity must match klog's -v flag,
+// klog filters lines by it before they reach the JSON logger.
+func SetLoggingFormat(format string, verbosity int) error {
+	switch format {
+	case LoggingFormatText:
+		klog.ClearLogger()
+	case LoggingFormatJSON:
+		klog.SetLogger(funcr.NewJSON(func(obj string) {
+			fmt.Fprintln(os.Stderr, obj)
+		}, funcr.Options{LogTimestamp: true, Verbosity: verbosity}))
+	default:
+		return fmt.Errorf("unknown logging format %q, must be %s or %s", format, LoggingFormatText, LoggingFormatJSON)
+	}
+
+	return nil
+}
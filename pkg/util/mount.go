@@ -0,0 +1,50 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/utils/mount"
+)
+
+// ContextMounter is a mount.Interface that can also mount under a context, see MountContext.
+type ContextMounter interface {
+	mount.Interface
+	MountContext(ctx context.Context, source string, target string, fstype string, options []string) error
+}
+
+type contextMounter struct {
+	mount.Interface
+	command string
+}
+
+// NewContextMounter returns a ContextMounter that mounts under a context by running the mount command, e.g. mount,
+// and otherwise leaves mount points to the mounter, which is also the one to clean up after mounts that were killed.
+func NewContextMounter(mounter mount.Interface, command string) ContextMounter {
+	return &contextMounter{Interface: mounter, command: command}
+}
+
+// MountContext mounts like mount.Interface but under the context, the mount command and the helpers it starts,
+// e.g. gcsfuse, are killed once the context is done. Callers must clean up the target path in that case.
+func (m *contextMounter) MountContext(ctx context.Context, source string, target string, fstype string, options []string) (err error) {
+	ctx, span := StartSpan(ctx, "Mount", attribute.String("source", source), attribute.String("target", target), attribute.String("fstype", fstype))
+	defer func() { EndSpan(span, err) }()
+
+	args := []string{"-t", fstype}
+	if len(options) > 0 {
+		args = append(args, "-o", strings.Join(options, ","))
+	}
+	args = append(args, source, target)
+
+	output, err := RunContext(ctx, m.command, args...)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("mount failed: %v\nMounting command: %s\nMounting arguments: %s\nOutput: %s", err, m.command, strings.Join(args, " "), output)
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+//go:build linux
+// +build linux
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// RunContext runs the command in its own process group and kills the whole group once the context is done, as
+// opposed to exec.CommandContext which only kills the command itself.
+func RunContext(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var output bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return output.Bytes(), err
+	case <-ctx.Done():
+		// A negative pid signals the process group
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return output.Bytes(), ctx.Err()
+	}
+}
@@ -0,0 +1,93 @@
+//go:build linux
+// +build linux
+
+package util_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/ofek/csi-gcs/pkg/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/mount"
+)
+
+var _ = Describe("Mount", func() {
+	Describe("RunContext", func() {
+		It("should return the output", func() {
+			output, err := RunContext(context.Background(), "sh", "-c", "echo mounted")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(output)).To(Equal("mounted\n"))
+		})
+
+		It("should kill the process group once the context is done", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			// The background sleep keeps the output open, it must be killed too for RunContext to return
+			start := time.Now()
+			_, err := RunContext(ctx, "sh", "-c", "sleep 10 & sleep 10")
+			Expect(err).To(Equal(context.DeadlineExceeded))
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		})
+	})
+
+	Describe("ContextMounter", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "mount")
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		// command returns a mount command running the script
+		command := func(script string) string {
+			path := filepath.Join(dir, "mount")
+			Expect(ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755)).To(Succeed())
+			return path
+		}
+
+		It("should run the mount command", func() {
+			output := filepath.Join(dir, "arguments")
+			mounter := NewContextMounter(mount.NewFakeMounter(nil), command(`echo "$@" > `+output))
+
+			Expect(mounter.MountContext(context.Background(), "bucket", "/tmp/target", "gcsfuse", []string{"ro", "uid=1000"})).To(Succeed())
+			Expect(ioutil.ReadFile(output)).To(BeEquivalentTo("-t gcsfuse -o ro,uid=1000 bucket /tmp/target\n"))
+		})
+
+		It("should return the output of failed mounts", func() {
+			mounter := NewContextMounter(mount.NewFakeMounter(nil), command("echo bucket not found; exit 1"))
+
+			err := mounter.MountContext(context.Background(), "bucket", "/tmp/target", "gcsfuse", nil)
+			Expect(err).To(MatchError(ContainSubstring("Output: bucket not found")))
+		})
+
+		It("should return the error of the context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			Expect(NewContextMounter(mount.NewFakeMounter(nil), "mount").MountContext(ctx, "bucket", "/tmp/target", "gcsfuse", nil)).To(Equal(context.Canceled))
+		})
+
+		It("should kill the mount command and its helpers once the context is done", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			// Like gcsfuse, the helper outlives the mount command
+			mounter := NewContextMounter(mount.NewFakeMounter(nil), command("sleep 10 & sleep 10"))
+
+			start := time.Now()
+			Expect(mounter.MountContext(ctx, "bucket", "/tmp/target", "gcsfuse", nil)).To(Equal(context.DeadlineExceeded))
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		})
+	})
+})
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package util
+
+import (
+	"context"
+	"errors"
+)
+
+// RunContext is only supported on Linux.
+func RunContext(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return nil, errors.New("running commands under a context is only supported on Linux")
+}
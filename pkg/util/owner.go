@@ -0,0 +1,47 @@
+package util
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodOwner returns the effective user and group the containers of the pod run as, or nil if they are not set.
+// Containers may override the security context of the pod, their value is only used if all containers agree.
+func PodOwner(pod *corev1.Pod) (uid *int64, gid *int64) {
+	var podUser, podGroup *int64
+	if context := pod.Spec.SecurityContext; context != nil {
+		podUser = context.RunAsUser
+		podGroup = context.RunAsGroup
+	}
+
+	var users, groups []*int64
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			user, group := podUser, podGroup
+			if context := container.SecurityContext; context != nil {
+				if context.RunAsUser != nil {
+					user = context.RunAsUser
+				}
+				if context.RunAsGroup != nil {
+					group = context.RunAsGroup
+				}
+			}
+			users = append(users, user)
+			groups = append(groups, group)
+		}
+	}
+
+	return commonID(users, podUser), commonID(groups, podGroup)
+}
+
+func commonID(ids []*int64, fallback *int64) *int64 {
+	if len(ids) == 0 || ids[0] == nil {
+		return fallback
+	}
+	for _, id := range ids[1:] {
+		if id == nil || *id != *ids[0] {
+			return fallback
+		}
+	}
+
+	return ids[0]
+}
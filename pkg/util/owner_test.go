@@ -0,0 +1,56 @@
+package util_test
+
+import (
+	. "github.com/ofek/csi-gcs/pkg/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("Owner", func() {
+	Describe("PodOwner", func() {
+		id := func(value int64) *int64 {
+			return &value
+		}
+
+		It("should use the security context of the pod", func() {
+			uid, gid := PodOwner(&corev1.Pod{Spec: corev1.PodSpec{
+				SecurityContext: &corev1.PodSecurityContext{RunAsUser: id(1000), RunAsGroup: id(2000)},
+				Containers:      []corev1.Container{{Name: "app"}},
+			}})
+			Expect(uid).To(Equal(id(1000)))
+			Expect(gid).To(Equal(id(2000)))
+		})
+
+		It("should use the security context of the containers if they agree", func() {
+			uid, gid := PodOwner(&corev1.Pod{Spec: corev1.PodSpec{
+				SecurityContext: &corev1.PodSecurityContext{RunAsUser: id(1000)},
+				InitContainers: []corev1.Container{
+					{Name: "init", SecurityContext: &corev1.SecurityContext{RunAsUser: id(3000)}},
+				},
+				Containers: []corev1.Container{
+					{Name: "app", SecurityContext: &corev1.SecurityContext{RunAsUser: id(3000), RunAsGroup: id(3000)}},
+				},
+			}})
+			Expect(uid).To(Equal(id(3000)))
+			Expect(gid).To(BeNil())
+		})
+
+		It("should fall back to the pod if the containers differ", func() {
+			uid, _ := PodOwner(&corev1.Pod{Spec: corev1.PodSpec{
+				SecurityContext: &corev1.PodSecurityContext{RunAsUser: id(1000)},
+				Containers: []corev1.Container{
+					{Name: "app", SecurityContext: &corev1.SecurityContext{RunAsUser: id(3000)}},
+					{Name: "sidecar"},
+				},
+			}})
+			Expect(uid).To(Equal(id(1000)))
+		})
+
+		It("should return nothing if unset", func() {
+			uid, gid := PodOwner(&corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}})
+			Expect(uid).To(BeNil())
+			Expect(gid).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,135 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// GCSBackoff is the backoff between the attempts of a GCS call, about 12 seconds of retries at most. Every delay is
+// jittered so that the calls of concurrent requests don't retry in lockstep.
+var GCSBackoff = wait.Backoff{
+	Duration: 250 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.5,
+	Steps:    5,
+}
+
+// RetryGCS runs the call until it succeeds, fails with an error that isn't retryable, or the backoff is exhausted.
+// It never waits past the deadline of the context, the last error is returned instead. Calls that aren't idempotent
+// are only retried if GCS rejected them without acting on them, see IsRetryableGCSError. The call must be made on a
+// handle returned by withoutClientRetries, so that the storage client doesn't retry it a second time.
+func RetryGCS(ctx context.Context, backoff wait.Backoff, idempotent bool, call func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := call(ctx)
+		if err == nil || ctx.Err() != nil || !IsRetryableGCSError(err, idempotent) || backoff.Steps < 1 {
+			return err
+		}
+
+		delay := backoff.Step()
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+
+		klog.FromContext(ctx).V(4).Info("Retrying GCS call", "attempt", attempt, "delay", delay, "err", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// withoutClientRetries returns a handle of the bucket, and of its objects, on which the storage client doesn't retry
+// failed calls itself, as RetryGCS does.
+func withoutClientRetries(bucket *storage.BucketHandle) *storage.BucketHandle {
+	return bucket.Retryer(storage.WithPolicy(storage.RetryNever))
+}
+
+// IsRetryableGCSError returns whether the call that failed with the error may succeed if retried. Rate limiting is
+// always retryable, server errors and network timeouts only if the call is idempotent, as GCS may have acted on it.
+func IsRetryableGCSError(err error, idempotent bool) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusTooManyRequests:
+			return true
+		case http.StatusRequestTimeout, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return idempotent
+		}
+		return false
+	}
+
+	if !idempotent {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.Is(err, io.ErrUnexpectedEOF) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// GCSErrorCode returns the gRPC code that best describes the error of a GCS call, Internal if there is none.
+func GCSErrorCode(err error) codes.Code {
+	switch {
+	case err == nil:
+		return codes.OK
+	case errors.Is(err, storage.ErrBucketNotExist), errors.Is(err, storage.ErrObjectNotExist):
+		return codes.NotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusBadRequest:
+			return codes.InvalidArgument
+		case http.StatusUnauthorized:
+			return codes.Unauthenticated
+		case http.StatusForbidden:
+			return codes.PermissionDenied
+		case http.StatusNotFound:
+			return codes.NotFound
+		case http.StatusConflict:
+			return codes.AlreadyExists
+		case http.StatusPreconditionFailed:
+			return codes.FailedPrecondition
+		case http.StatusTooManyRequests:
+			return codes.ResourceExhausted
+		case http.StatusServiceUnavailable:
+			return codes.Unavailable
+		}
+		return codes.Internal
+	}
+
+	if s, ok := status.FromError(err); ok {
+		return s.Code()
+	}
+
+	return codes.Internal
+}
+
+// GCSErrorf returns a gRPC error for the error of a GCS call, with the code given by GCSErrorCode.
+func GCSErrorf(err error, format string, args ...interface{}) error {
+	return status.Errorf(GCSErrorCode(err), format, args...)
+}
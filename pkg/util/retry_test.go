@@ -0,0 +1,208 @@
+package util_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/storage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	. "github.com/ofek/csi-gcs/pkg/util"
+)
+
+var _ = Describe("Retry", func() {
+	backoff := wait.Backoff{Duration: time.Millisecond, Factor: 2, Jitter: 0.5, Steps: 3}
+
+	Describe("RetryGCS", func() {
+		It("should retry retryable errors until the call succeeds", func() {
+			calls := 0
+			err := RetryGCS(context.Background(), backoff, true, func(ctx context.Context) error {
+				calls++
+				if calls < 3 {
+					return &googleapi.Error{Code: http.StatusServiceUnavailable}
+				}
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(calls).To(Equal(3))
+		})
+
+		It("should return the last error once the backoff is exhausted", func() {
+			calls := 0
+			err := RetryGCS(context.Background(), backoff, true, func(ctx context.Context) error {
+				calls++
+				return &googleapi.Error{Code: http.StatusTooManyRequests}
+			})
+			Expect(GCSErrorCode(err)).To(Equal(codes.ResourceExhausted))
+			Expect(calls).To(Equal(4))
+		})
+
+		It("should not retry errors that aren't retryable", func() {
+			calls := 0
+			err := RetryGCS(context.Background(), backoff, true, func(ctx context.Context) error {
+				calls++
+				return &googleapi.Error{Code: http.StatusForbidden}
+			})
+			Expect(GCSErrorCode(err)).To(Equal(codes.PermissionDenied))
+			Expect(calls).To(Equal(1))
+		})
+
+		It("should only retry calls that aren't idempotent if they were rate limited", func() {
+			calls := 0
+			err := RetryGCS(context.Background(), backoff, false, func(ctx context.Context) error {
+				calls++
+				return &googleapi.Error{Code: http.StatusServiceUnavailable}
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(calls).To(Equal(1))
+		})
+
+		It("should not wait past the deadline of the context", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			calls := 0
+			start := time.Now()
+			err := RetryGCS(ctx, wait.Backoff{Duration: time.Second, Factor: 2, Steps: 3}, true, func(ctx context.Context) error {
+				calls++
+				return &googleapi.Error{Code: http.StatusServiceUnavailable}
+			})
+			Expect(GCSErrorCode(err)).To(Equal(codes.Unavailable))
+			Expect(calls).To(Equal(1))
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+	})
+
+	Describe("IsRetryableGCSError", func() {
+		It("should always retry rate limiting", func() {
+			Expect(IsRetryableGCSError(&googleapi.Error{Code: http.StatusTooManyRequests}, false)).To(BeTrue())
+		})
+
+		It("should only retry server errors of idempotent calls", func() {
+			Expect(IsRetryableGCSError(&googleapi.Error{Code: http.StatusServiceUnavailable}, true)).To(BeTrue())
+			Expect(IsRetryableGCSError(fmt.Errorf("listing: %w", &googleapi.Error{Code: http.StatusBadGateway}), true)).To(BeTrue())
+			Expect(IsRetryableGCSError(&googleapi.Error{Code: http.StatusServiceUnavailable}, false)).To(BeFalse())
+		})
+
+		It("should not retry other errors", func() {
+			Expect(IsRetryableGCSError(&googleapi.Error{Code: http.StatusConflict}, true)).To(BeFalse())
+			Expect(IsRetryableGCSError(storage.ErrBucketNotExist, true)).To(BeFalse())
+			Expect(IsRetryableGCSError(context.DeadlineExceeded, true)).To(BeFalse())
+		})
+	})
+
+	Describe("GCSErrorCode", func() {
+		It("should map HTTP status codes to gRPC codes", func() {
+			Expect(GCSErrorCode(&googleapi.Error{Code: http.StatusForbidden})).To(Equal(codes.PermissionDenied))
+			Expect(GCSErrorCode(&googleapi.Error{Code: http.StatusNotFound})).To(Equal(codes.NotFound))
+			Expect(GCSErrorCode(&googleapi.Error{Code: http.StatusConflict})).To(Equal(codes.AlreadyExists))
+			Expect(GCSErrorCode(&googleapi.Error{Code: http.StatusPreconditionFailed})).To(Equal(codes.FailedPrecondition))
+			Expect(GCSErrorCode(&googleapi.Error{Code: http.StatusTooManyRequests})).To(Equal(codes.ResourceExhausted))
+			Expect(GCSErrorCode(&googleapi.Error{Code: http.StatusUnauthorized})).To(Equal(codes.Unauthenticated))
+			Expect(GCSErrorCode(&googleapi.Error{Code: http.StatusInternalServerError})).To(Equal(codes.Internal))
+		})
+
+		It("should map errors of the storage client", func() {
+			Expect(GCSErrorCode(storage.ErrBucketNotExist)).To(Equal(codes.NotFound))
+			Expect(GCSErrorCode(fmt.Errorf("prefix: %w", storage.ErrObjectNotExist))).To(Equal(codes.NotFound))
+		})
+
+		It("should keep gRPC codes and default to Internal", func() {
+			Expect(GCSErrorCode(status.Error(codes.Aborted, "aborted"))).To(Equal(codes.Aborted))
+			Expect(GCSErrorCode(errors.New("unknown"))).To(Equal(codes.Internal))
+		})
+	})
+
+	Describe("Bucket calls", func() {
+		var (
+			server   *httptest.Server
+			bucket   *storage.BucketHandle
+			requests int32
+			statuses []int
+			previous wait.Backoff
+		)
+
+		BeforeEach(func() {
+			requests = 0
+			statuses = nil
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(atomic.AddInt32(&requests, 1))
+				code := statuses[len(statuses)-1]
+				if n <= len(statuses) {
+					code = statuses[n-1]
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(code)
+				if code == http.StatusOK {
+					fmt.Fprint(w, `{"kind": "storage#objects"}`)
+					return
+				}
+				fmt.Fprintf(w, `{"error": {"code": %d, "message": "%s"}}`, code, http.StatusText(code))
+			}))
+
+			client, err := storage.NewClient(context.Background(), option.WithEndpoint(server.URL+"/storage/v1/"), option.WithoutAuthentication())
+			Expect(err).NotTo(HaveOccurred())
+			bucket = client.Bucket("test")
+
+			previous = GCSBackoff
+			GCSBackoff = backoff
+		})
+
+		AfterEach(func() {
+			GCSBackoff = previous
+			server.Close()
+		})
+
+		It("should only retry as often as the backoff allows", func() {
+			statuses = []int{http.StatusServiceUnavailable}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			_, err := GetBucketAttrs(ctx, bucket)
+			Expect(GCSErrorCode(err)).To(Equal(codes.Unavailable))
+			Expect(atomic.LoadInt32(&requests)).To(BeEquivalentTo(4))
+		})
+
+		It("should not retry a failed creation", func() {
+			statuses = []int{http.StatusServiceUnavailable}
+
+			err := CreateBucket(context.Background(), bucket, "project", &storage.BucketAttrs{})
+			Expect(GCSErrorCode(err)).To(Equal(codes.Unavailable))
+			Expect(atomic.LoadInt32(&requests)).To(BeEquivalentTo(1))
+		})
+
+		It("should treat a missing bucket on a retried deletion as deleted", func() {
+			statuses = []int{http.StatusServiceUnavailable, http.StatusNotFound}
+
+			Expect(DeleteBucket(context.Background(), bucket)).To(Succeed())
+			Expect(atomic.LoadInt32(&requests)).To(BeEquivalentTo(2))
+		})
+
+		It("should retry checking if the bucket exists", func() {
+			statuses = []int{http.StatusServiceUnavailable, http.StatusNotFound}
+
+			exists, err := BucketExists(context.Background(), bucket)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exists).To(BeFalse())
+			Expect(atomic.LoadInt32(&requests)).To(BeEquivalentTo(2))
+		})
+
+		It("should retry deleting a prefix", func() {
+			statuses = []int{http.StatusTooManyRequests, http.StatusOK}
+
+			Expect(DeletePrefix(context.Background(), bucket, "volume")).To(Succeed())
+			Expect(atomic.LoadInt32(&requests)).To(BeEquivalentTo(3))
+		})
+	})
+})
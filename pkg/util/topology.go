@@ -0,0 +1,42 @@
+package util
+
+import (
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// LocationFromTopology picks the bucket location from the region of the most preferred topology, falling back to
+// the requisite topologies. It returns an empty string if no topology has a region.
+func LocationFromTopology(requirement *csi.TopologyRequirement, regionKey string) string {
+	for _, topologies := range [][]*csi.Topology{requirement.GetPreferred(), requirement.GetRequisite()} {
+		for _, topology := range topologies {
+			if region := topology.GetSegments()[regionKey]; region != "" {
+				return region
+			}
+		}
+	}
+
+	return ""
+}
+
+// AccessibleTopology returns the region topologies of the requirement in which a bucket at the given location
+// resides. Buckets in multi-regions or dual-regions are accessible from everywhere, so no topology is returned.
+func AccessibleTopology(requirement *csi.TopologyRequirement, regionKey string, location string) []*csi.Topology {
+	var result []*csi.Topology
+	seen := map[string]bool{}
+
+	for _, topologies := range [][]*csi.Topology{requirement.GetRequisite(), requirement.GetPreferred()} {
+		for _, topology := range topologies {
+			region := topology.GetSegments()[regionKey]
+			if region == "" || seen[region] || !strings.EqualFold(region, location) {
+				continue
+			}
+			seen[region] = true
+
+			result = append(result, &csi.Topology{Segments: map[string]string{regionKey: region}})
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,50 @@
+package util_test
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/ofek/csi-gcs/pkg/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const regionKey = "topology.gcs.csi.ofek.dev/region"
+
+func topology(region string, zone string) *csi.Topology {
+	return &csi.Topology{Segments: map[string]string{
+		regionKey:                        region,
+		"topology.gcs.csi.ofek.dev/zone": zone,
+	}}
+}
+
+var _ = Describe("Topology", func() {
+	requirement := &csi.TopologyRequirement{
+		Requisite: []*csi.Topology{topology("us-east1", "us-east1-b"), topology("us-central1", "us-central1-a")},
+		Preferred: []*csi.Topology{topology("us-central1", "us-central1-a"), topology("us-east1", "us-east1-b")},
+	}
+
+	Describe("LocationFromTopology", func() {
+		It("should use the most preferred region", func() {
+			Expect(LocationFromTopology(requirement, regionKey)).To(Equal("us-central1"))
+		})
+
+		It("should fall back to requisite regions", func() {
+			Expect(LocationFromTopology(&csi.TopologyRequirement{Requisite: requirement.Requisite}, regionKey)).To(Equal("us-east1"))
+		})
+
+		It("should be empty without requirements", func() {
+			Expect(LocationFromTopology(nil, regionKey)).To(BeEmpty())
+		})
+	})
+
+	Describe("AccessibleTopology", func() {
+		It("should restrict regional buckets to their region", func() {
+			Expect(AccessibleTopology(requirement, regionKey, "US-CENTRAL1")).To(Equal([]*csi.Topology{
+				{Segments: map[string]string{regionKey: "us-central1"}},
+			}))
+		})
+
+		It("should not restrict multi-region buckets", func() {
+			Expect(AccessibleTopology(requirement, regionKey, "US")).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,144 @@
+package util
+
+import (
+	"context"
+
+	"cloud.google.com/go/storage"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2/google"
+	grpccodes "google.golang.org/grpc/codes"
+)
+
+// TracerName names the tracer of all spans created by the driver.
+const TracerName = "github.com/ofek/csi-gcs"
+
+// TracingOptions configures the export of traces over OTLP.
+type TracingOptions struct {
+	// Endpoint is the host and port of the OTLP gRPC receiver, e.g. an OpenTelemetry collector.
+	Endpoint string
+	// Insecure disables TLS towards the endpoint.
+	Insecure bool
+	// SampleRatio is the fraction of requests that are traced, unless the caller decided already.
+	SampleRatio float64
+	// ServiceName and ServiceVersion identify the driver in the traces.
+	ServiceName    string
+	ServiceVersion string
+}
+
+// SetupTracing installs a tracer provider exporting spans over OTLP, the returned function flushes and stops it.
+// Without it spans are not recorded.
+func SetupTracing(ctx context.Context, options TracingOptions) (func(context.Context) error, error) {
+	exporterOptions := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(options.Endpoint)}
+	if options.Insecure {
+		exporterOptions = append(exporterOptions, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, exporterOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(options.ServiceName),
+		semconv.ServiceVersion(options.ServiceVersion),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// StartSpan starts a child span of the span in the context, it must be ended with EndSpan.
+func StartSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// EndSpan ends the span, marking it as failed if there was an error.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// FindDefaultCredentials looks up the default credentials of the driver, which may involve the metadata server.
+func FindDefaultCredentials(ctx context.Context, scopes ...string) (creds *google.Credentials, err error) {
+	ctx, span := StartSpan(ctx, "google.FindDefaultCredentials")
+	defer func() { EndSpan(span, err) }()
+
+	return google.FindDefaultCredentials(ctx, scopes...)
+}
+
+// GetBucketAttrs returns the attributes of the bucket.
+func GetBucketAttrs(ctx context.Context, bucket *storage.BucketHandle) (attrs *storage.BucketAttrs, err error) {
+	ctx, span := StartSpan(ctx, "storage.Bucket.Attrs")
+	defer func() { EndSpan(span, err) }()
+	bucket = withoutClientRetries(bucket)
+
+	err = RetryGCS(ctx, GCSBackoff, true, func(ctx context.Context) (err error) {
+		attrs, err = bucket.Attrs(ctx)
+		return err
+	})
+	return attrs, err
+}
+
+// CreateBucket creates the bucket in the project. A failed creation is only retried if it was rate limited, as a
+// retry of a creation that went through would conflict with the bucket it created.
+func CreateBucket(ctx context.Context, bucket *storage.BucketHandle, projectID string, attrs *storage.BucketAttrs) (err error) {
+	ctx, span := StartSpan(ctx, "storage.Bucket.Create", attribute.String("project", projectID))
+	defer func() { EndSpan(span, err) }()
+	bucket = withoutClientRetries(bucket)
+
+	return RetryGCS(ctx, GCSBackoff, false, func(ctx context.Context) error {
+		return bucket.Create(ctx, projectID, attrs)
+	})
+}
+
+// UpdateBucket updates the attributes of the bucket.
+func UpdateBucket(ctx context.Context, bucket *storage.BucketHandle, attrs storage.BucketAttrsToUpdate) (updated *storage.BucketAttrs, err error) {
+	ctx, span := StartSpan(ctx, "storage.Bucket.Update")
+	defer func() { EndSpan(span, err) }()
+	bucket = withoutClientRetries(bucket)
+
+	err = RetryGCS(ctx, GCSBackoff, true, func(ctx context.Context) (err error) {
+		updated, err = bucket.Update(ctx, attrs)
+		return err
+	})
+	return updated, err
+}
+
+// DeleteBucket deletes the bucket, which must be empty. The bucket not existing on a retry means an earlier attempt
+// deleted it.
+func DeleteBucket(ctx context.Context, bucket *storage.BucketHandle) (err error) {
+	ctx, span := StartSpan(ctx, "storage.Bucket.Delete")
+	defer func() { EndSpan(span, err) }()
+	bucket = withoutClientRetries(bucket)
+
+	retried := false
+	return RetryGCS(ctx, GCSBackoff, true, func(ctx context.Context) error {
+		err := bucket.Delete(ctx)
+		if retried && GCSErrorCode(err) == grpccodes.NotFound {
+			return nil
+		}
+		retried = true
+		return err
+	})
+}
@@ -0,0 +1,73 @@
+package util_test
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/utils/mount"
+
+	. "github.com/ofek/csi-gcs/pkg/util"
+)
+
+var _ = Describe("Tracing", func() {
+	var (
+		recorder *tracetest.SpanRecorder
+		previous trace.TracerProvider
+	)
+
+	BeforeEach(func() {
+		previous = otel.GetTracerProvider()
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	})
+
+	AfterEach(func() {
+		otel.SetTracerProvider(previous)
+	})
+
+	It("Should record child spans", func() {
+		ctx, parent := StartSpan(context.Background(), "parent")
+		_, child := StartSpan(ctx, "child", attribute.String("bucket", "test"))
+		EndSpan(child, nil)
+		EndSpan(parent, nil)
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(2))
+		Expect(spans[0].Name()).To(Equal("child"))
+		Expect(spans[0].Parent().SpanID()).To(Equal(parent.SpanContext().SpanID()))
+		Expect(spans[0].Attributes()).To(ContainElement(attribute.String("bucket", "test")))
+		Expect(spans[0].Status().Code).To(Equal(codes.Unset))
+	})
+
+	It("Should mark failed spans", func() {
+		_, span := StartSpan(context.Background(), "failed")
+		EndSpan(span, errors.New("bucket doesn't exist"))
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Status().Code).To(Equal(codes.Error))
+		Expect(spans[0].Status().Description).To(Equal("bucket doesn't exist"))
+		Expect(spans[0].Events()).To(HaveLen(1))
+	})
+
+	It("Should trace mounts", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		Expect(NewContextMounter(mount.NewFakeMounter(nil), "mount").MountContext(ctx, "bucket", "/tmp/target", "gcsfuse", nil)).To(MatchError(context.Canceled))
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Name()).To(Equal("Mount"))
+		Expect(spans[0].Attributes()).To(ContainElement(attribute.String("target", "/tmp/target")))
+		Expect(spans[0].Status().Code).To(Equal(codes.Error))
+	})
+})
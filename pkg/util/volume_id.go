@@ -0,0 +1,80 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	VolumeIDVersion = "v1"
+
+	// volumeIDUnknownProject stands in for the project of buckets that were not provisioned by the driver.
+	// It can't collide with real project IDs, which only contain lowercase letters, digits and hyphens.
+	volumeIDUnknownProject = "_"
+)
+
+// VolumeID identifies the bucket, or the prefix of a bucket, backing a volume.
+//
+// Volume IDs are encoded as `v1/<project>/<bucket>[/<prefix>]`. Any other volume ID is a legacy one, e.g. the
+// handle of a static PersistentVolume, and is taken as an opaque bucket name so existing volumes keep working.
+type VolumeID struct {
+	Version string
+	Project string
+	Bucket  string
+	Prefix  string
+}
+
+func NewVolumeID(project string, bucket string, prefix string) *VolumeID {
+	return &VolumeID{
+		Version: VolumeIDVersion,
+		Project: project,
+		Bucket:  bucket,
+		Prefix:  strings.Trim(prefix, "/"),
+	}
+}
+
+// ParseVolumeID decodes a volume ID in either the versioned or the legacy format.
+func ParseVolumeID(volumeId string) (*VolumeID, error) {
+	if volumeId == "" {
+		return nil, fmt.Errorf("empty volume id")
+	}
+
+	if !strings.HasPrefix(volumeId, VolumeIDVersion+"/") {
+		return &VolumeID{Bucket: volumeId}, nil
+	}
+
+	parts := strings.SplitN(volumeId, "/", 4)
+	if len(parts) < 3 || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("malformed volume id %q, expected %s/<project>/<bucket>[/<prefix>]", volumeId, VolumeIDVersion)
+	}
+
+	id := &VolumeID{Version: parts[0], Bucket: parts[2]}
+	if parts[1] != volumeIDUnknownProject {
+		id.Project = parts[1]
+	}
+	if len(parts) == 4 {
+		id.Prefix = strings.Trim(parts[3], "/")
+	}
+
+	return id, nil
+}
+
+// IsLegacy reports whether the volume ID was parsed from the unversioned format.
+func (v *VolumeID) IsLegacy() bool {
+	return v.Version == ""
+}
+
+// String encodes the volume ID in the current versioned format.
+func (v *VolumeID) String() string {
+	project := v.Project
+	if project == "" {
+		project = volumeIDUnknownProject
+	}
+
+	id := VolumeIDVersion + "/" + project + "/" + v.Bucket
+	if v.Prefix != "" {
+		id += "/" + v.Prefix
+	}
+
+	return id
+}
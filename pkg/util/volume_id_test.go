@@ -0,0 +1,61 @@
+package util_test
+
+import (
+	. "github.com/ofek/csi-gcs/pkg/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("VolumeID", func() {
+	Describe("ParseVolumeID", func() {
+		It("should parse legacy bucket volumes", func() {
+			Expect(ParseVolumeID("my-bucket")).To(Equal(&VolumeID{Bucket: "my-bucket"}))
+		})
+
+		It("should take any legacy volume id as an opaque bucket name", func() {
+			Expect(ParseVolumeID("team/data")).To(Equal(&VolumeID{Bucket: "team/data"}))
+			Expect(ParseVolumeID("ab")).To(Equal(&VolumeID{Bucket: "ab"}))
+			Expect(ParseVolumeID("v1")).To(Equal(&VolumeID{Bucket: "v1"}))
+			Expect(ParseVolumeID("v2/my-project/my-bucket")).To(Equal(&VolumeID{Bucket: "v2/my-project/my-bucket"}))
+		})
+
+		It("should parse versioned volumes", func() {
+			Expect(ParseVolumeID("v1/my-project/my-bucket")).To(Equal(&VolumeID{
+				Version: "v1",
+				Project: "my-project",
+				Bucket:  "my-bucket",
+			}))
+			Expect(ParseVolumeID("v1/_/my-bucket/pvc-1")).To(Equal(&VolumeID{
+				Version: "v1",
+				Bucket:  "my-bucket",
+				Prefix:  "pvc-1",
+			}))
+		})
+
+		It("should reject malformed volume ids", func() {
+			for _, id := range []string{"", "v1/", "v1/my-project", "v1//my-bucket", "v1/my-project/"} {
+				_, err := ParseVolumeID(id)
+				Expect(err).To(HaveOccurred(), id)
+			}
+		})
+	})
+
+	Describe("String", func() {
+		It("should round trip", func() {
+			for _, id := range []*VolumeID{
+				NewVolumeID("my-project", "my-bucket", ""),
+				NewVolumeID("my-project", "my-bucket", "teams/a"),
+				NewVolumeID("", "my-bucket", "pvc-1"),
+			} {
+				Expect(ParseVolumeID(id.String())).To(Equal(id))
+			}
+		})
+
+		It("should upgrade legacy volume ids", func() {
+			id, err := ParseVolumeID("my-bucket")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(id.IsLegacy()).To(BeTrue())
+			Expect(id.String()).To(Equal("v1/_/my-bucket"))
+		})
+	})
+})
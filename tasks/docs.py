@@ -22,6 +22,12 @@ def insert_verbosity_flag(command, verbosity):
         command.insert(1, f"-{'v' * abs(verbosity)}")
 
 
+@task
+def options(ctx):
+    """Generate the reference documentation of the driver options"""
+    ctx.run('go run ./hack/gen-options-doc', echo=True)
+
+
 @task(
     help={'verbose': 'Increase verbosity (can be used additively)'},
     incrementable=['verbose'],
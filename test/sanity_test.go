@@ -6,8 +6,12 @@ import (
 	"testing"
 
 	"github.com/kubernetes-csi/csi-test/v3/pkg/sanity"
+	gcsfake "github.com/ofek/csi-gcs/pkg/client/clientset/clientset/fake"
 	"github.com/ofek/csi-gcs/pkg/driver"
-	"k8s.io/klog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/klog/v2"
 )
 
 func TestCsiGcs(t *testing.T) {
@@ -35,7 +39,11 @@ func TestCsiGcs(t *testing.T) {
 	var endpoint = "unix://"
 	endpoint += endpointFile.Name()
 
-	d, err := driver.NewGCSDriver(driver.CSIDriverName, "test-node", endpoint, "development", false)
+	// The Kubernetes API is faked, mounts are registered on the node
+	clientset := k8sfake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}})
+	gcsClientset := gcsfake.NewSimpleClientset()
+
+	d, err := driver.NewGCSDriver(driver.CSIDriverName, "test-node", endpoint, "development", clientset, gcsClientset, driver.GCSDriverOptions{})
 	if err != nil {
 		klog.Error(err.Error())
 		os.Exit(1)